@@ -0,0 +1,173 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditType is the type of an AuditEvent
+type AuditType string
+
+// types of AuditEvent
+const (
+	AuditLoginStarted   AuditType = "login_started"
+	AuditLoginSucceeded AuditType = "login_succeeded"
+	AuditLoginFailed    AuditType = "login_failed"
+	AuditRoleDenied     AuditType = "role_denied"
+	AuditLogout         AuditType = "logout"
+	AuditTokenRefreshed AuditType = "token_refreshed"
+	AuditStateMismatch  AuditType = "state_mismatch"
+)
+
+// AuditEvent is an authentication activity for the audit trail
+type AuditEvent struct {
+	Type      AuditType `json:"type"`
+	Time      time.Time `json:"time"`
+	UID       string    `json:"uid,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	UserAgent string    `json:"ua,omitempty"`
+	Reason    string    `json:"reason,omitempty"` // error code of login_failed, or the reason of a policy of role_denied
+	Role      string    `json:"role,omitempty"`   // the missing role of role_denied
+}
+
+// AuditSubscriber receives AuditEvents, it is called synchronously and should not block
+type AuditSubscriber interface {
+	OnAudit(ctx context.Context, ev AuditEvent)
+}
+
+// AuditFunc is a function as AuditSubscriber
+type AuditFunc func(ctx context.Context, ev AuditEvent)
+
+// OnAudit calls f
+func (f AuditFunc) OnAudit(ctx context.Context, ev AuditEvent) {
+	f(ctx, ev)
+}
+
+// WithAuditor adds subscribers of AuditEvents
+func WithAuditor(subs ...AuditSubscriber) Option {
+	return func(c *Client) {
+		c.auditors = append(c.auditors, subs...)
+	}
+}
+
+// WithTrustedProxies sets the proxies (IPs or CIDRs) whose X-Forwarded-For is trusted for the client IP
+func WithTrustedProxies(proxies ...string) Option {
+	return func(c *Client) {
+		for _, s := range proxies {
+			s = strings.TrimSpace(s)
+			if len(s) == 0 {
+				continue
+			}
+			if !strings.Contains(s, "/") {
+				if addr, err := netip.ParseAddr(s); err == nil {
+					c.trustedProxies = append(c.trustedProxies, netip.PrefixFrom(addr, addr.BitLen()))
+					continue
+				}
+			}
+			if p, err := netip.ParsePrefix(s); err == nil {
+				c.trustedProxies = append(c.trustedProxies, p.Masked())
+			} else {
+				slog.Warn("invalid trusted proxy", "proxy", s, "err", err)
+			}
+		}
+	}
+}
+
+// ClientIP returns the IP of the request, X-Forwarded-For is respected only from the trusted proxies,
+// the rightmost untrusted address of it is the client.
+func (c *Client) ClientIP(r *http.Request) string {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	if !c.isTrustedProxy(ip) {
+		return ip
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if len(hop) == 0 {
+			continue
+		}
+		ip = hop
+		if !c.isTrustedProxy(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+func (c *Client) isTrustedProxy(s string) bool {
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range c.trustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// audit emits the event to subscribers, with the IP and the user agent of r if not nil
+func (c *Client) audit(ctx context.Context, r *http.Request, ev AuditEvent) {
+	if len(c.auditors) == 0 {
+		return
+	}
+	ev.Time = time.Now()
+	if r != nil {
+		ev.IP = c.ClientIP(r)
+		ev.UserAgent = r.UserAgent()
+	}
+	for _, sub := range c.auditors {
+		sub.OnAudit(ctx, ev)
+	}
+}
+
+// FileAuditSink appends AuditEvents to a file as JSON lines
+type FileAuditSink struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+var _ AuditSubscriber = (*FileAuditSink)(nil)
+
+// NewFileAuditSink opens the file for appending, creates it if not exists
+func NewFileAuditSink(path string) (*FileAuditSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileAuditSink{f: f}, nil
+}
+
+// OnAudit writes the event as a line, failures are logged only
+func (s *FileAuditSink) OnAudit(ctx context.Context, ev AuditEvent) {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	b = append(b, '\n')
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err = s.f.Write(b); err != nil {
+		slog.Warn("write audit event fail", "err", err)
+	}
+}
+
+// Close closes the file
+func (s *FileAuditSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.f.Close()
+}
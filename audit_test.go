@@ -0,0 +1,119 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_ClientIP(t *testing.T) {
+	c := New(WithTrustedProxies("10.0.0.0/8", "192.168.1.1", "bad"))
+	tests := []struct {
+		name   string
+		remote string
+		xff    string
+		want   string
+	}{
+		{"无代理", "1.2.3.4:5678", "", "1.2.3.4"},
+		{"不信任的来源忽略 XFF", "1.2.3.4:5678", "9.9.9.9", "1.2.3.4"},
+		{"信任的代理", "10.1.2.3:80", "9.9.9.9", "9.9.9.9"},
+		{"多级代理取最右不信任", "10.1.2.3:80", "6.6.6.6, 9.9.9.9, 192.168.1.1", "9.9.9.9"},
+		{"全部信任取最左", "10.1.2.3:80", "10.9.9.9", "10.9.9.9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			if len(tt.xff) > 0 {
+				r.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			assert.Equal(t, tt.want, c.ClientIP(r))
+		})
+	}
+}
+
+func TestClient_Audit(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"access_token": "at", "token_type": "Bearer", "expires_in": 3600})
+	})
+	mux.HandleFunc("/info/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "at", "me": map[string]string{"uid": "alice"}, "group": []string{"staff"},
+		})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	sink, err := NewFileAuditSink(path)
+	require.NoError(t, err)
+	var mu sync.Mutex
+	var events []AuditEvent
+	c := New(WithPrefix(srv.URL), WithClientID("cid", "secret"), WithAuditor(sink, AuditFunc(func(ctx context.Context, ev AuditEvent) {
+		mu.Lock()
+		events = append(events, ev)
+		mu.Unlock()
+	})))
+
+	login := func(state string, roles ...string) {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/auth/login", nil)
+		r.Header.Set("User-Agent", "test-agent")
+		authURL := c.LoginStart(rec, r)
+		u, _ := url.Parse(authURL)
+		if len(state) == 0 {
+			state = u.Query().Get("state")
+		}
+		r = requestWithCookies(rec)
+		r.URL.RawQuery = url.Values{"state": {state}, "code": {"c1"}}.Encode()
+		c.AuthCodeCallback(roles...).ServeHTTP(httptest.NewRecorder(), r)
+	}
+	login("", "staff")
+	login("", "admin")
+	login("forged")
+
+	require.NoError(t, sink.Close())
+	want := []AuditEvent{
+		{Type: AuditLoginStarted},
+		{Type: AuditLoginSucceeded, UID: "alice"},
+		{Type: AuditLoginStarted},
+		{Type: AuditRoleDenied, UID: "alice", Role: "admin"},
+		{Type: AuditLoginFailed, Reason: "missing_role"},
+		{Type: AuditLoginStarted},
+		{Type: AuditStateMismatch, Reason: "invalid_state"},
+	}
+	require.Len(t, events, len(want))
+	for i, ev := range events {
+		assert.Equal(t, want[i].Type, ev.Type)
+		assert.Equal(t, want[i].UID, ev.UID)
+		assert.Equal(t, want[i].Role, ev.Role)
+		assert.Equal(t, want[i].Reason, ev.Reason)
+		assert.NotEmpty(t, ev.IP)
+		assert.False(t, ev.Time.IsZero())
+	}
+	assert.Equal(t, "test-agent", events[0].UserAgent)
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	var lines int
+	for sc := bufio.NewScanner(f); sc.Scan(); lines++ {
+		var ev AuditEvent
+		require.NoError(t, json.Unmarshal(sc.Bytes(), &ev))
+		assert.Equal(t, want[lines].Type, ev.Type)
+	}
+	assert.Equal(t, len(want), lines)
+}
@@ -0,0 +1,183 @@
+package client
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	auth "github.com/liut/simpauth"
+)
+
+// Policy decides whether a request is allowed with the roles of its user
+type Policy func(r *http.Request, roles auth.Names) bool
+
+// RequireAnyRole returns a middleware of the default client which requires the user has any of the roles
+func RequireAnyRole(roles ...string) func(next http.Handler) http.Handler {
+	return Default().RequireAnyRole(roles...)
+}
+
+// RequireAnyRole returns a middleware which requires the user has any of the roles
+func (c *Client) RequireAnyRole(roles ...string) func(next http.Handler) http.Handler {
+	return c.RequirePolicy(AnyRole(roles...), fmt.Sprintf("any of roles %v required", roles))
+}
+
+// RequireAllRoles returns a middleware of the default client which requires the user has all of the roles
+func RequireAllRoles(roles ...string) func(next http.Handler) http.Handler {
+	return Default().RequireAllRoles(roles...)
+}
+
+// RequireAllRoles returns a middleware which requires the user has all of the roles
+func (c *Client) RequireAllRoles(roles ...string) func(next http.Handler) http.Handler {
+	return c.RequirePolicy(AllRoles(roles...), fmt.Sprintf("all of roles %v required", roles))
+}
+
+// RequireNoRole returns a middleware of the default client which requires the user has none of the roles
+func RequireNoRole(roles ...string) func(next http.Handler) http.Handler {
+	return Default().RequireNoRole(roles...)
+}
+
+// RequireNoRole returns a middleware which requires the user has none of the roles
+func (c *Client) RequireNoRole(roles ...string) func(next http.Handler) http.Handler {
+	return c.RequirePolicy(NoRole(roles...), fmt.Sprintf("none of roles %v allowed", roles))
+}
+
+// RequireRoleExpr returns a middleware of the default client which requires the roles satisfy the expression
+func RequireRoleExpr(e *RoleExpr) func(next http.Handler) http.Handler {
+	return Default().RequireRoleExpr(e)
+}
+
+// RequireRoleExpr returns a middleware which requires the roles of the user satisfy the expression,
+// it panics if the expression is nil, see RoleExprPolicy.
+func (c *Client) RequireRoleExpr(e *RoleExpr) func(next http.Handler) http.Handler {
+	return c.RequirePolicy(RoleExprPolicy(e), fmt.Sprintf("roles %s required", e))
+}
+
+// RequireMeta returns a middleware of the default client which requires the meta of InfoToken has the value
+func RequireMeta(key string, value any) func(next http.Handler) http.Handler {
+	return Default().RequireMeta(key, value)
+}
+
+// RequireMeta returns a middleware which requires the meta of InfoToken has the value, see InfoTokenFromRequest.
+// A cookie session without a TokenStore has no InfoToken, and is denied.
+func (c *Client) RequireMeta(key string, value any) func(next http.Handler) http.Handler {
+	p := func(r *http.Request, _ auth.Names) bool {
+		it, err := c.InfoTokenFromRequest(r)
+		if err != nil {
+			slog.Info("meta unavailable", "key", key, "err", err)
+			return false
+		}
+		v, ok := it.Meta.Get(key)
+		return ok && fmt.Sprint(v) == fmt.Sprint(value)
+	}
+	return c.RequirePolicy(p, fmt.Sprintf("meta %s=%v required", key, value))
+}
+
+// RequirePolicy returns a middleware of the default client which authorizes the user with the policy
+func RequirePolicy(p Policy, reason string) func(next http.Handler) http.Handler {
+	return Default().RequirePolicy(p, reason)
+}
+
+// RequirePolicy returns a middleware which authorizes the user in context with the policy,
+// it responds 401 if no user found, and 403 with the reason if the policy denies,
+// rendered by the ErrorHandler of the client. A denial is audited as role_denied with the reason,
+// and counted in the role denials metric with the reason as the role.
+func (c *Client) RequirePolicy(p Policy, reason string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := UserFromContext(r.Context())
+			if !ok {
+				c.handleError(w, r, NewOAuthError("unauthorized", "sign in required", http.StatusUnauthorized))
+				return
+			}
+			if !p(r, RolesFromRequest(r)) {
+				c.tel().denied(r.Context(), reason)
+				c.audit(r.Context(), r, AuditEvent{Type: AuditRoleDenied, UID: user.GetUID(), Reason: reason})
+				c.handleError(w, r, NewOAuthError("forbidden", reason, http.StatusForbidden))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// InfoTokenFromRequest returns the InfoToken put by the bearer middleware, or for a cookie session,
+// the one requested with the token of the user in the TokenStore, set WithInfoCache to cache it.
+func (c *Client) InfoTokenFromRequest(r *http.Request) (*InfoToken, error) {
+	ctx := r.Context()
+	if it, ok := InfoTokenFromContext(ctx); ok {
+		return it, nil
+	}
+	user, ok := UserFromContext(ctx)
+	if !ok {
+		return nil, ErrNoToken
+	}
+	ts, err := c.TokenSourceForUser(ctx, user.GetUID())
+	if err != nil {
+		return nil, err
+	}
+	tok, err := ts.Token()
+	if err != nil {
+		return nil, err
+	}
+	return c.RequestInfoToken(ctx, tok)
+}
+
+// AnyRole is a Policy which allows any of the roles, matched exactly
+func AnyRole(roles ...string) Policy {
+	return func(_ *http.Request, names auth.Names) bool {
+		return RoleSet(names).Any(roles...)
+	}
+}
+
+// AllRoles is a Policy which allows all of the roles
+func AllRoles(roles ...string) Policy {
+	return func(_ *http.Request, names auth.Names) bool {
+		return RoleSet(names).All(roles...)
+	}
+}
+
+// NoRole is a Policy which allows none of the roles
+func NoRole(roles ...string) Policy {
+	return func(_ *http.Request, names auth.Names) bool {
+		return RoleSet(names).None(roles...)
+	}
+}
+
+// RoleExprPolicy is a Policy which allows the roles satisfying the expression,
+// it panics if the expression is nil or zero, which would allow nothing.
+func RoleExprPolicy(e *RoleExpr) Policy {
+	if e == nil || e.root == nil {
+		panic("staffio: RoleExprPolicy with an empty expression")
+	}
+	return func(_ *http.Request, names auth.Names) bool {
+		return e.Eval(RoleSet(names))
+	}
+}
+
+// RolesFromRequest returns the roles of the user in context, with InfoToken.Roles put by the bearer middleware
+func RolesFromRequest(r *http.Request) (roles auth.Names) {
+	ctx := r.Context()
+	if user, ok := UserFromContext(ctx); ok {
+		roles = append(roles, userRoles(user)...)
+	}
+	if it, ok := InfoTokenFromContext(ctx); ok {
+		for _, rn := range it.Roles {
+			if !roles.Has(rn) {
+				roles = append(roles, rn)
+			}
+		}
+	}
+	return
+}
+
+func userRoles(user auth.IUser) auth.Names {
+	switch u := user.(type) {
+	case *auth.User:
+		return u.Roles
+	case *O2User:
+		return u.Roles
+	case interface{ GetRoles() auth.Names }:
+		return u.GetRoles()
+	}
+	return nil
+}
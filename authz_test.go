@@ -0,0 +1,147 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"golang.org/x/oauth2"
+)
+
+func TestRequirePolicy(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	withUser := func(roles ...string) context.Context {
+		user := &User{UID: "alice", Roles: roles}
+		return ContextWithUser(context.Background(), user)
+	}
+
+	tests := []struct {
+		name     string
+		mw       func(next http.Handler) http.Handler
+		ctx      context.Context
+		expected int
+	}{
+		{"无用户", RequireAnyRole("admin"), context.Background(), http.StatusUnauthorized},
+		{"任一角色命中", RequireAnyRole("admin", "ops"), withUser("ops"), http.StatusOK},
+		{"任一角色未命中", RequireAnyRole("admin", "ops"), withUser("dev"), http.StatusForbidden},
+		{"全部角色命中", RequireAllRoles("ops", "oncall"), withUser("ops", "oncall"), http.StatusOK},
+		{"全部角色缺一", RequireAllRoles("ops", "oncall"), withUser("ops"), http.StatusForbidden},
+		{"角色精确匹配", RequireAnyRole("ops.db"), withUser("ops.*"), http.StatusForbidden},
+		{"查询通配不生效", RequireAnyRole("ops.*"), withUser("ops.db"), http.StatusForbidden},
+		{"表达式通配", RequireRoleExpr(MustParseRoleExpr("ops.*")), withUser("ops.db"), http.StatusOK},
+		{"排除角色", RequireNoRole("intern"), withUser("staff"), http.StatusOK},
+		{"排除角色命中", RequireNoRole("intern"), withUser("staff", "intern"), http.StatusForbidden},
+		{"表达式命中", RequireRoleExpr(MustParseRoleExpr("admin || (ops && oncall)")), withUser("ops", "oncall"), http.StatusOK},
+		{"表达式未命中", RequireRoleExpr(MustParseRoleExpr("admin || (ops && oncall)")), withUser("ops"), http.StatusForbidden},
+		{"InfoToken角色", RequireAnyRole("admin"),
+			context.WithValue(withUser(), InfoTokenKey, &InfoToken{Roles: []string{"admin"}}), http.StatusOK},
+		{"Meta命中", RequireMeta("dept", "ops"),
+			context.WithValue(withUser(), InfoTokenKey, &InfoToken{Meta: Meta{"dept": "ops"}}), http.StatusOK},
+		{"Meta未命中", RequireMeta("dept", "ops"), withUser(), http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(tt.ctx)
+			tt.mw(ok).ServeHTTP(rec, r)
+			assert.Equal(t, tt.expected, rec.Code)
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(withUser("dev"))
+	r.Header.Set("Accept", "application/json")
+	RequireAnyRole("admin")(ok).ServeHTTP(rec, r)
+	var ie InfoError
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&ie))
+	assert.Equal(t, "forbidden", ie.ErrCode)
+}
+
+func TestClient_RequireMeta_Session(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "at", "me": map[string]string{"uid": "alice"}, "meta": map[string]any{"dept": "ops"},
+		})
+	}))
+	defer srv.Close()
+
+	store := NewMemoryTokenStore()
+	require.NoError(t, store.Put(context.Background(), "alice", &oauth2.Token{AccessToken: "at", Expiry: time.Now().Add(time.Hour)}))
+	var handled error
+	c := New(WithPrefix(srv.URL), WithTokenStore(store), WithErrorHandler(func(w http.ResponseWriter, r *http.Request, err error) {
+		handled = err
+		w.WriteHeader(AsOAuthError(err).GetStatus())
+	}))
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name string
+		uid  string
+		mw   func(next http.Handler) http.Handler
+		want int
+		code string
+	}{
+		{"会话 Meta 命中", "alice", c.RequireMeta("dept", "ops"), http.StatusOK, ""},
+		{"会话 Meta 未命中", "alice", c.RequireMeta("dept", "dev"), http.StatusForbidden, "forbidden"},
+		{"无存储令牌", "bob", c.RequireMeta("dept", "ops"), http.StatusForbidden, "forbidden"},
+		{"无用户", "", c.RequireMeta("dept", "ops"), http.StatusUnauthorized, "unauthorized"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handled = nil
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if len(tt.uid) > 0 {
+				r = r.WithContext(ContextWithUser(r.Context(), &User{UID: tt.uid}))
+			}
+			rec := httptest.NewRecorder()
+			tt.mw(ok).ServeHTTP(rec, r)
+			assert.Equal(t, tt.want, rec.Code)
+			if len(tt.code) > 0 {
+				require.Error(t, handled, "routed to the error handler")
+				assert.Equal(t, tt.code, AsOAuthError(handled).Code)
+			}
+		})
+	}
+}
+
+func TestClient_RequirePolicy_Denied(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	var events []AuditEvent
+	c := New(WithPrefix("https://sso.example.com"), WithClientID("cid", "secret"), WithTelemetry(nil, mp),
+		WithAuditor(AuditFunc(func(ctx context.Context, ev AuditEvent) { events = append(events, ev) })))
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	for _, roles := range [][]string{{"admin"}, {"dev"}} {
+		rec := httptest.NewRecorder()
+		ctx := ContextWithUser(context.Background(), &User{UID: "alice", Roles: roles})
+		c.RequireAnyRole("admin")(ok).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+	}
+
+	require.Len(t, events, 1)
+	assert.Equal(t, AuditRoleDenied, events[0].Type)
+	assert.Equal(t, "alice", events[0].UID)
+	assert.Equal(t, "any of roles [admin] required", events[0].Reason)
+
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	var denials int64
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if d, is := m.Data.(metricdata.Sum[int64]); is && m.Name == "staffio.role.denials" {
+				for _, dp := range d.DataPoints {
+					denials += dp.Value
+				}
+			}
+		}
+	}
+	assert.EqualValues(t, 1, denials)
+}
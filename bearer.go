@@ -0,0 +1,355 @@
+package client
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// Introspection is the response of RFC 7662 token introspection
+type Introspection struct {
+	Active    bool     `json:"active"`
+	Scope     string   `json:"scope,omitempty"`
+	ClientID  string   `json:"client_id,omitempty"`
+	Username  string   `json:"username,omitempty"`
+	TokenType string   `json:"token_type,omitempty"`
+	Expiry    int64    `json:"exp,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	Issuer    string   `json:"iss,omitempty"`
+	Roles     []string `json:"roles,omitempty"` // extension
+}
+
+// WithIntrospectionURI sets the RFC 7662 introspection endpoint, relative one is joined with the prefix
+func WithIntrospectionURI(uri string) Option {
+	return func(c *Client) {
+		c.introspectURI = uri
+	}
+}
+
+// IntrospectionURI returns the introspection endpoint, empty if not configured or discovered
+func (c *Client) IntrospectionURI() string {
+	if len(c.introspectURI) > 0 {
+		return FixURI(c.prefix, c.introspectURI)
+	}
+	if c.meta != nil {
+		return c.meta.IntrospectionEndpoint
+	}
+	return ""
+}
+
+// Introspect calls the introspection endpoint with the client credentials
+func (c *Client) Introspect(ctx context.Context, token string) (*Introspection, error) {
+	uri := c.IntrospectionURI()
+	if len(uri) == 0 {
+		return nil, fmt.Errorf("introspection endpoint not configured")
+	}
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, uri, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(c.conf.ClientID), url.QueryEscape(c.conf.ClientSecret))
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		slog.Info("introspect fail", "err", err, "uri", uri)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	ti := new(Introspection)
+	if err = decodeResponse(resp, ti); err != nil {
+		return nil, err
+	}
+	return ti, nil
+}
+
+// InfoToken builds an InfoToken from an active introspection
+func (ti *Introspection) InfoToken(token string) *InfoToken {
+	user := &O2User{Sub: ti.Subject}
+	user.OID = ti.Subject
+	user.UID = ti.Username
+	if len(user.UID) == 0 {
+		user.UID = ti.Subject
+	}
+	it := &InfoToken{
+		AccessToken: token,
+		TokenType:   ti.TokenType,
+		User:        user,
+		Roles:       ti.Roles,
+		Scope:       ti.Scope,
+	}
+	if ti.Expiry > 0 {
+		it.Expiry = time.Unix(ti.Expiry, 0)
+		it.ExpiresIn = int64(time.Until(it.Expiry).Seconds())
+	}
+	return it
+}
+
+// BearerAuth validates `Authorization: Bearer` access tokens at the provider
+type BearerAuth struct {
+	// Client is the client to use, nil means the default client.
+	Client *Client
+	// Roles specifies the roles required, all of them.
+	Roles []string
+	// Scopes specifies the scopes required, all of them.
+	Scopes []string
+	// TTL is how long a valid token is cached, default 1 minute, capped by the token expiry.
+	TTL time.Duration
+	// NegativeTTL is how long a token rejected by the provider is cached, default 10 seconds.
+	// Failures to reach the provider are not cached.
+	NegativeTTL time.Duration
+}
+
+// errTokenInactive is the error of a token which the introspection reports inactive
+var errTokenInactive = errors.New("token is not active")
+
+type bearerEntry struct {
+	key     string
+	it      *InfoToken
+	err     error
+	subs    []string // uid and sub of the user, for logouts
+	expires time.Time
+}
+
+// maxBearerEntries is the max number of cached validations of a client
+const maxBearerEntries = 10000
+
+// bearerCache caches the validations of bearer tokens for all BearerAuths of a client,
+// keyed like the InfoCache, so that InvalidateInfo and logouts drop them.
+// It is an LRU of at most maxBearerEntries.
+type bearerCache struct {
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+func (bc *bearerCache) get(key string, now time.Time) (bearerEntry, bool) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	el, ok := bc.items[key]
+	if !ok {
+		return bearerEntry{}, false
+	}
+	e := el.Value.(*bearerEntry)
+	if !now.Before(e.expires) {
+		bc.remove(el)
+		return bearerEntry{}, false
+	}
+	bc.ll.MoveToFront(el)
+	return *e, true
+}
+
+func (bc *bearerCache) put(key string, e bearerEntry, now time.Time) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if bc.items == nil {
+		bc.ll, bc.items = list.New(), make(map[string]*list.Element)
+	}
+	e.key = key
+	if el, ok := bc.items[key]; ok {
+		el.Value = &e
+		bc.ll.MoveToFront(el)
+		return
+	}
+	bc.items[key] = bc.ll.PushFront(&e)
+	for bc.ll.Len() > maxBearerEntries {
+		bc.remove(bc.ll.Back())
+	}
+}
+
+// remove drops the element, bc.mu must be held
+func (bc *bearerCache) remove(el *list.Element) {
+	bc.ll.Remove(el)
+	delete(bc.items, el.Value.(*bearerEntry).key)
+}
+
+// forget drops the entries of the access token key, with any roles
+func (bc *bearerCache) forget(prefix string) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	for k, el := range bc.items {
+		if k == prefix || strings.HasPrefix(k, prefix+"|") {
+			bc.remove(el)
+		}
+	}
+}
+
+// forgetUser drops the entries of the user, by uid or sub
+func (bc *bearerCache) forgetUser(sub string) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	for _, el := range bc.items {
+		if slices.Contains(el.Value.(*bearerEntry).subs, sub) {
+			bc.remove(el)
+		}
+	}
+}
+
+// BearerMiddleware returns a middleware which validates bearer tokens with required roles
+func BearerMiddleware(roles ...string) func(next http.Handler) http.Handler {
+	return Default().BearerMiddleware(roles...)
+}
+
+// BearerMiddleware returns a middleware which validates bearer tokens with required roles
+func (c *Client) BearerMiddleware(roles ...string) func(next http.Handler) http.Handler {
+	ba := &BearerAuth{Client: c, Roles: roles}
+	return ba.Middleware()
+}
+
+// Middleware returns a middleware which puts the user and the InfoToken into the request context
+func (ba *BearerAuth) Middleware() func(next http.Handler) http.Handler {
+	c := ba.Client
+	if c == nil {
+		c = Default()
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := BearerToken(r)
+			if len(token) == 0 {
+				bearerError(w, http.StatusUnauthorized, "invalid_request", "bearer token required")
+				return
+			}
+			it, rejected, err := ba.validate(r.Context(), c, token)
+			if err != nil && !rejected {
+				slog.Warn("bearer token validation fail", "err", err)
+				bearerError(w, http.StatusServiceUnavailable, "temporarily_unavailable", "the provider is unavailable")
+				return
+			}
+			if err != nil {
+				slog.Info("bearer token invalid", "err", err)
+				bearerError(w, http.StatusUnauthorized, "invalid_token", "the access token is invalid")
+				return
+			}
+			for _, rn := range ba.Roles {
+				if !it.HasRole(rn) {
+					c.tel().denied(r.Context(), rn)
+					c.audit(r.Context(), r, AuditEvent{Type: AuditRoleDenied, UID: it.uid(), Role: rn})
+					bearerError(w, http.StatusForbidden, "insufficient_scope", "role "+rn+" required")
+					return
+				}
+			}
+			for _, sn := range ba.Scopes {
+				if !it.HasScope(sn) {
+					bearerError(w, http.StatusForbidden, "insufficient_scope", "scope "+sn+" required")
+					return
+				}
+			}
+			user, ok := it.GetUser()
+			if !ok {
+				bearerError(w, http.StatusUnauthorized, "invalid_token", "user not found")
+				return
+			}
+			ctx := ContextWithUser(r.Context(), user)
+			ctx = context.WithValue(ctx, InfoTokenKey, it)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// validate returns a copy of the InfoToken of the token, rejected is true if the provider rejects the token,
+// otherwise the error is a failure to reach the provider, which is not cached.
+func (ba *BearerAuth) validate(ctx context.Context, c *Client, token string) (it *InfoToken, rejected bool, err error) {
+	key := infoCacheKey(token, ba.Roles)
+	now := time.Now()
+	if e, ok := c.bearers.get(key, now); ok {
+		if e.err != nil {
+			return nil, true, e.err
+		}
+		return e.it.clone(), false, nil
+	}
+
+	if len(c.IntrospectionURI()) > 0 {
+		var ti *Introspection
+		if ti, err = c.Introspect(ctx, token); err == nil {
+			if !ti.Active {
+				err, rejected = errTokenInactive, true
+			} else {
+				it = ti.InfoToken(token)
+				c.expandRoles(it)
+			}
+		}
+	} else {
+		it, err = c.RequestInfoToken(ctx, &oauth2.Token{AccessToken: token, TokenType: "Bearer"}, c.requestRoles(ba.Roles)...)
+		rejected = err != nil && isTokenRejected(err)
+	}
+	if err != nil && !rejected {
+		return nil, false, err
+	}
+
+	e := bearerEntry{it: it, err: err}
+	if err != nil {
+		e.expires = now.Add(durationOr(ba.NegativeTTL, 10*time.Second))
+	} else {
+		e.expires = now.Add(durationOr(ba.TTL, time.Minute))
+		if !it.Expiry.IsZero() && it.Expiry.Before(e.expires) {
+			e.expires = it.Expiry
+		}
+		subs := []string{it.uid()}
+		if it.User != nil {
+			subs = append(subs, it.User.Sub, it.User.OID)
+		}
+		for _, s := range subs {
+			if len(s) > 0 {
+				e.subs = append(e.subs, s)
+			}
+		}
+	}
+	c.bearers.put(key, e, now)
+	if err != nil {
+		return nil, true, err
+	}
+	return it.clone(), false, nil
+}
+
+// isTokenRejected checks the error of the info endpoint is a definite rejection of the token,
+// rather than a failure of the provider
+func isTokenRejected(err error) bool {
+	var re *ResponseError
+	if errors.As(err, &re) {
+		return re.StatusCode == http.StatusBadRequest || re.StatusCode == http.StatusUnauthorized ||
+			re.StatusCode == http.StatusForbidden
+	}
+	var oe *OAuthError
+	return errors.As(err, &oe) && oe.GetStatus() < 500
+}
+
+// BearerToken returns the token of the `Authorization: Bearer` header
+func BearerToken(r *http.Request) string {
+	if ah := r.Header.Get("Authorization"); len(ah) > 7 && strings.EqualFold(ah[:7], "bearer ") {
+		return strings.TrimSpace(ah[7:])
+	}
+	return ""
+}
+
+// InfoTokenFromContext returns the InfoToken put by the bearer middleware
+func InfoTokenFromContext(ctx context.Context) (*InfoToken, bool) {
+	it, ok := ctx.Value(InfoTokenKey).(*InfoToken)
+	return it, ok
+}
+
+func bearerError(w http.ResponseWriter, code int, errCode, desc string) {
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf("Bearer error=%q, error_description=%q", errCode, desc))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(InfoError{ErrCode: errCode, ErrMessage: desc})
+}
+
+func durationOr(d, dft time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	return dft
+}
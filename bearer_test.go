@@ -0,0 +1,192 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBearerAuth_Middleware(t *testing.T) {
+	var infoHits, introHits atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/info/", func(w http.ResponseWriter, r *http.Request) {
+		infoHits.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		if r.Header.Get("Authorization") != "Bearer good" {
+			_ = json.NewEncoder(w).Encode(InfoError{ErrCode: "invalid_token"})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "good", "expires_in": 3600,
+			"me":    map[string]string{"uid": "alice", "nickname": "Alice"},
+			"group": []string{"admin"},
+		})
+	})
+	mux.HandleFunc("/introspect", func(w http.ResponseWriter, r *http.Request) {
+		introHits.Add(1)
+		_ = r.ParseForm()
+		user, pass, _ := r.BasicAuth()
+		assert.Equal(t, "cid", user)
+		assert.Equal(t, "secret", pass)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(Introspection{
+			Active: r.PostForm.Get("token") == "good", Username: "bob", Subject: "b1", Scope: "read write",
+		})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	var seen *InfoToken
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := UserFromContext(r.Context())
+		assert.True(t, ok)
+		seen, _ = InfoTokenFromContext(r.Context())
+		_, _ = w.Write([]byte(user.GetUID()))
+	})
+	serve := func(h http.Handler, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/api", nil)
+		if len(token) > 0 {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		return rec
+	}
+
+	t.Run("info", func(t *testing.T) {
+		c := New(WithPrefix(srv.URL))
+		h := c.BearerMiddleware("admin")(handler)
+
+		assert.Equal(t, http.StatusUnauthorized, serve(h, "").Code)
+		rec := serve(h, "good")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "alice", rec.Body.String())
+		assert.Equal(t, "good", seen.AccessToken)
+		serve(h, "good")
+		assert.EqualValues(t, 1, infoHits.Load(), "cached")
+
+		rec = serve(h, "bad")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Contains(t, rec.Header().Get("WWW-Authenticate"), `error="invalid_token"`)
+		serve(h, "bad")
+		assert.EqualValues(t, 2, infoHits.Load(), "negative cached")
+
+		h = c.BearerMiddleware("owner")(handler)
+		assert.Equal(t, http.StatusForbidden, serve(h, "good").Code)
+	})
+
+	t.Run("introspection", func(t *testing.T) {
+		c := New(WithPrefix(srv.URL), WithClientID("cid", "secret"), WithIntrospectionURI("introspect"))
+		ba := &BearerAuth{Client: c, Scopes: []string{"read"}}
+		h := ba.Middleware()(handler)
+
+		rec := serve(h, "good")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "bob", rec.Body.String())
+		assert.Equal(t, http.StatusUnauthorized, serve(h, "bad").Code)
+		assert.EqualValues(t, 2, introHits.Load())
+
+		ba = &BearerAuth{Client: c, Scopes: []string{"admin"}}
+		assert.Equal(t, http.StatusForbidden, serve(ba.Middleware()(handler), "good").Code)
+	})
+}
+
+func TestBearerAuth_Cache(t *testing.T) {
+	var hits atomic.Int32
+	var down atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if down.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "good", "expires_in": 3600, "user": map[string]string{"uid": "alice", "sub": "a1"},
+		})
+	}))
+	defer srv.Close()
+
+	c := New(WithPrefix(srv.URL))
+	h := c.BearerMiddleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		it, _ := InfoTokenFromContext(r.Context())
+		user, _ := it.GetUser()
+		user.Refresh()
+	}))
+	serve := func() int {
+		r := httptest.NewRequest(http.MethodGet, "/api", nil)
+		r.Header.Set("Authorization", "Bearer good")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		return rec.Code
+	}
+
+	tests := []struct {
+		name  string
+		setup func()
+		want  int
+		hits  int32
+	}{
+		{"提供方故障返回 503", func() { down.Store(true) }, http.StatusServiceUnavailable, 1},
+		{"故障不缓存", func() { down.Store(false) }, http.StatusOK, 2},
+		{"有效令牌缓存", func() {}, http.StatusOK, 2},
+		{"InvalidateInfo 清除缓存", func() { _ = c.InvalidateInfo(context.Background(), "good") }, http.StatusOK, 3},
+		{"后端登出清除缓存", func() { c.revokeSessions("a1") }, http.StatusOK, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.setup()
+			assert.Equal(t, tt.want, serve())
+			assert.Equal(t, tt.hits, hits.Load())
+		})
+	}
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Equal(t, http.StatusOK, serve())
+		}()
+	}
+	wg.Wait()
+}
+
+func TestBearerCache_LRU(t *testing.T) {
+	var bc bearerCache
+	now := time.Now()
+	bc.put("a", bearerEntry{subs: []string{"alice"}, expires: now.Add(time.Minute)}, now)
+	bc.put("b", bearerEntry{err: errTokenInactive, expires: now.Add(time.Second)}, now)
+
+	_, ok := bc.get("a", now)
+	assert.True(t, ok)
+	_, ok = bc.get("b", now.Add(2*time.Second))
+	assert.False(t, ok, "expired")
+	assert.Equal(t, 1, bc.ll.Len(), "expired entry dropped")
+
+	for i := range maxBearerEntries {
+		bc.put("k"+strconv.Itoa(i), bearerEntry{err: errTokenInactive, expires: now.Add(time.Second)}, now)
+	}
+	assert.Equal(t, maxBearerEntries, bc.ll.Len())
+	assert.Len(t, bc.items, maxBearerEntries)
+	_, ok = bc.get("a", now)
+	assert.False(t, ok, "the least recently used is evicted")
+	_, ok = bc.get("k0", now)
+	assert.True(t, ok)
+
+	bc.put("a", bearerEntry{subs: []string{"alice"}, expires: now.Add(time.Minute)}, now)
+	bc.forgetUser("alice")
+	_, ok = bc.get("a", now)
+	assert.False(t, ok)
+	bc.forget("k0")
+	_, ok = bc.get("k0", now)
+	assert.False(t, ok)
+}
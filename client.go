@@ -0,0 +1,329 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/sync/singleflight"
+
+	auth "github.com/liut/simpauth"
+)
+
+// Client is an OAuth2 client for a Staffio (or compatible) provider.
+// Package level functions are thin wrappers over the Default instance.
+type Client struct {
+	auth.Authorizer
+
+	prefix      string
+	authURI     string
+	tokenURI    string
+	infoURI     string
+	redirectURL string
+
+	introspectURI string
+	revokeURI     string
+	endSessionURI string
+	postLogoutURL string
+	adminPath     string
+	loginPath     string
+
+	allowedHosts   []string
+	trustedProxies []netip.Prefix
+
+	conf         *oauth2.Config
+	httpClient   *http.Client
+	errorHandler ErrorHandler
+	storeMu      sync.RWMutex // guards stateStore and tokenStore, which may be registered later
+	stateStore   StateStore
+	tokenStore   TokenStore
+	infoCache    InfoCache
+	services     serviceSources
+	retry        *RetryPolicy
+	telemetry    *telemetry
+	telemetryUID bool
+	auditors     []AuditSubscriber
+
+	tokenExtraKeys []string // nil for defaultTokenExtraKeys
+
+	revocations sessionRevocations
+	logins      loginIndex
+	bearers     bearerCache
+
+	infoMu    sync.Mutex
+	infoRoles map[string]struct{} // role sets requested, for invalidation
+	infoGroup singleflight.Group
+
+	revalidate      bool
+	revalidateRoles []string
+	revalidateGroup singleflight.Group
+
+	roleHierarchy RoleHierarchy
+	requiredRoles *RoleExpr
+
+	oidc bool
+	meta *ProviderMetadata
+	keys *keySet
+}
+
+var (
+	_ IClient = (*Client)(nil)
+
+	dftClient  atomic.Pointer[Client]
+	dftMu      sync.Mutex
+	dftEnv     *Client   // the default client built from environment, guarded by dftMu
+	dftRetryAt time.Time // the next discovery of Default after a failure, guarded by dftMu
+)
+
+// discoverRetryInterval is the minimum interval of the discovery retries of Default
+const discoverRetryInterval = 30 * time.Second
+
+// Option configures a Client
+type Option func(c *Client)
+
+// WithPrefix sets the base URL of the provider
+func WithPrefix(s string) Option {
+	return func(c *Client) {
+		if len(s) > 0 {
+			c.prefix = strings.TrimSuffix(s, "/")
+		}
+	}
+}
+
+// WithClientID sets the client ID and secret
+func WithClientID(clientID, clientSecret string) Option {
+	return func(c *Client) {
+		SetupClient(c.conf, clientID, clientSecret)
+	}
+}
+
+// WithRedirectURL sets the redirect URL, a path starts with "/" is resolved with the request host
+func WithRedirectURL(s string) Option {
+	return func(c *Client) {
+		if len(s) > 0 {
+			c.redirectURL = s
+		}
+	}
+}
+
+// WithScopes sets the scopes of authorization request
+func WithScopes(scopes ...string) Option {
+	return func(c *Client) {
+		SetupScopes(c.conf, scopes)
+	}
+}
+
+// WithEndpoints sets URIs of authorize, token and info, relative ones are joined with the prefix
+func WithEndpoints(authorize, token, info string) Option {
+	return func(c *Client) {
+		if len(authorize) > 0 {
+			c.authURI = authorize
+		}
+		if len(token) > 0 {
+			c.tokenURI = token
+		}
+		if len(info) > 0 {
+			c.infoURI = info
+		}
+	}
+}
+
+// WithPaths sets the admin path (redirect after signed in) and the login path
+func WithPaths(admin, login string) Option {
+	return func(c *Client) {
+		if len(admin) > 0 {
+			c.adminPath = admin
+		}
+		if len(login) > 0 {
+			c.loginPath = login
+		}
+	}
+}
+
+// WithHTTPClient sets the http.Client for requests to the provider
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		if hc != nil {
+			c.httpClient = hc
+		}
+	}
+}
+
+// WithStateStore sets the StateStore
+func WithStateStore(ss StateStore) Option {
+	return func(c *Client) {
+		if ss != nil {
+			c.stateStore = ss
+		}
+	}
+}
+
+// WithAuthorizer sets the Authorizer for local user session
+func WithAuthorizer(a auth.Authorizer) Option {
+	return func(c *Client) {
+		if a != nil {
+			c.Authorizer = a
+		}
+	}
+}
+
+// New returns a Client with options. Without WithAuthorizer, the session cookie is named
+// by CookieName, so that clients of different providers or IDs do not share it.
+func New(opts ...Option) *Client {
+	c := &Client{
+		prefix:      "https://staffio.work",
+		authURI:     "authorize",
+		tokenURI:    "token",
+		infoURI:     "info/me",
+		redirectURL: "/auth/callback",
+		conf:        new(oauth2.Config),
+		httpClient:  httpClient,
+	}
+	for _, fn := range opts {
+		fn(c)
+	}
+	c.httpClient = c.wrapHTTPClient(c.httpClient)
+	if c.Authorizer == nil {
+		c.Authorizer = auth.New(auth.WithCookie(CookieName(c.prefix, c.conf.ClientID), "/"))
+	}
+	if c.stateStore == nil {
+		c.stateStore = newStateStore()
+	}
+	c.conf.Endpoint = oauth2.Endpoint{
+		AuthURL:  FixURI(c.prefix, c.authURI),
+		TokenURL: FixURI(c.prefix, c.tokenURI),
+	}
+	c.infoURI = FixURI(c.prefix, c.infoURI)
+	SetupRedirectURL(c.conf, c.redirectURL)
+	return c
+}
+
+// Default returns the default Client, which configured from environment.
+// Invalid values in the environment are logged only, for compatibility, and a failed OIDC
+// discovery is logged and retried after a while, use DefaultE to handle the errors.
+func Default() *Client {
+	if c := dftClient.Load(); c != nil && c.discovered() {
+		return c
+	}
+	dftMu.Lock()
+	defer dftMu.Unlock()
+	old := dftClient.Load()
+	if old != nil && (old != dftEnv || old.discovered() || time.Now().Before(dftRetryAt)) {
+		return old
+	}
+	opts, err := envOptions()
+	if err != nil {
+		slog.Warn("invalid config in environment", "err", err)
+	}
+	c := New(opts...)
+	c.inherit(old)
+	if err = discoverDefault(c); err != nil {
+		slog.Warn("default client discovery fail, retry later", "err", err)
+		dftRetryAt = time.Now().Add(discoverRetryInterval)
+		if old != nil {
+			return old
+		}
+	}
+	dftEnv = c
+	dftClient.Store(c)
+	return c
+}
+
+// DefaultE returns the default Client, which configured from environment, with the error of
+// the invalid config or the OIDC discovery. A failed client is not kept, the next call tries again.
+func DefaultE() (*Client, error) {
+	if c := dftClient.Load(); c != nil && c.discovered() {
+		return c, nil
+	}
+	dftMu.Lock()
+	defer dftMu.Unlock()
+	old := dftClient.Load()
+	if old != nil && (old != dftEnv || old.discovered()) {
+		return old, nil
+	}
+	opts, err := envOptions()
+	if err != nil {
+		return nil, err
+	}
+	c := New(opts...)
+	c.inherit(old)
+	if err = discoverDefault(c); err != nil {
+		return nil, err
+	}
+	dftEnv = c
+	dftClient.Store(c)
+	return c, nil
+}
+
+// discoverDefault discovers the provider of a default client in OIDC mode
+func discoverDefault(c *Client) error {
+	if !c.oidc {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+	return c.Discover(ctx)
+}
+
+// discovered reports whether the client is ready, that is not in OIDC mode or discovered
+func (c *Client) discovered() bool {
+	return !c.oidc || c.meta != nil
+}
+
+// inherit keeps the stores and the http client set on an undiscovered default client,
+// which is replaced by a rebuilt one
+func (c *Client) inherit(old *Client) {
+	if old == nil {
+		return
+	}
+	old.storeMu.RLock()
+	c.stateStore, c.tokenStore = old.stateStore, old.tokenStore
+	old.storeMu.RUnlock()
+	c.httpClient = old.httpClient
+}
+
+// SetDefault replaces the default Client, which is used by the package level functions
+func SetDefault(c *Client) {
+	dftClient.Store(c)
+}
+
+// CookieName returns the session cookie name of a client without an explicit Authorizer,
+// derived from the prefix and the client ID, it is stable across instances.
+func CookieName(prefix, clientID string) string {
+	sum := sha256.Sum256([]byte(prefix + "|" + clientID))
+	return "_user_" + hex.EncodeToString(sum[:4])
+}
+
+// Prefix returns the base URL of the provider
+func (c *Client) Prefix() string {
+	return c.prefix
+}
+
+// Config returns the oauth2 config
+func (c *Client) Config() *oauth2.Config {
+	return c.conf
+}
+
+// AdminPath returns the path to redirect after signed in, default is the package AdminPath
+func (c *Client) AdminPath() string {
+	if len(c.adminPath) > 0 {
+		return c.adminPath
+	}
+	return AdminPath
+}
+
+// LoginPath returns the login path, default is the package LoginPath
+func (c *Client) LoginPath() string {
+	if len(c.loginPath) > 0 {
+		return c.loginPath
+	}
+	return LoginPath
+}
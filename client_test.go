@@ -0,0 +1,119 @@
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultE_InvalidEnv(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{"缺少 client id", map[string]string{"CLIENT_ID": ""}, "client_id"},
+		{"缺少 client secret", map[string]string{"CLIENT_SECRET": ""}, "client_secret"},
+		{"state secret 过短", map[string]string{"OAUTH_STATE_SECRET": "short"}, "state_secret"},
+		{"TLS 文件缺失", map[string]string{"OAUTH_CA_FILE": "/nonexistent/ca.pem"}, "ca.pem"},
+	}
+	old := dftClient.Load()
+	defer SetDefault(old)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CLIENT_ID", "test-id")
+			t.Setenv("CLIENT_SECRET", "test-secret")
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			SetDefault(nil)
+			_, err := DefaultE()
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tt.want)
+			}
+			assert.Nil(t, dftClient.Load(), "not kept")
+			// the implicit default logs the invalid environment only
+			assert.NotPanics(t, func() { assert.NotNil(t, Default()) })
+		})
+	}
+}
+
+func TestDefault_NoCredentials(t *testing.T) {
+	t.Setenv("CLIENT_ID", "")
+	t.Setenv("CLIENT_SECRET", "")
+	old := dftClient.Load()
+	defer SetDefault(old)
+	SetDefault(nil)
+
+	var h http.Handler
+	assert.NotPanics(t, func() {
+		h = Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	})
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/", nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestNew_Tenants(t *testing.T) {
+	c1 := New(WithPrefix("https://one.example.com/"), WithClientID("id1", "s1"), WithScopes("openid"))
+	c2 := New(WithPrefix("https://two.example.com"), WithClientID("id2", "s2"),
+		WithEndpoints("/oauth/authorize", "https://sso.example.com/token", "api/me"),
+		WithPaths("/dash/", "/login"))
+
+	assert.Equal(t, "https://one.example.com/authorize", c1.Config().Endpoint.AuthURL)
+	assert.Equal(t, "https://one.example.com/token", c1.Config().Endpoint.TokenURL)
+	assert.Equal(t, "https://one.example.com/info/me", c1.infoURI)
+	assert.Equal(t, "https://two.example.com/oauth/authorize", c2.Config().Endpoint.AuthURL)
+	assert.Equal(t, "https://sso.example.com/token", c2.Config().Endpoint.TokenURL)
+	assert.Equal(t, "https://two.example.com/api/me", c2.infoURI)
+
+	assert.Equal(t, AdminPath, c1.AdminPath())
+	assert.Equal(t, "/dash/", c2.AdminPath())
+	assert.Equal(t, "/login", c2.LoginPath())
+
+	r := httptest.NewRequest(http.MethodGet, "/auth/login", nil)
+	loc1 := c1.LoginStart(httptest.NewRecorder(), r)
+	loc2 := c2.LoginStart(httptest.NewRecorder(), r)
+	assert.True(t, strings.HasPrefix(loc1, "https://one.example.com/authorize?"))
+	assert.Contains(t, loc1, "client_id=id1")
+	assert.True(t, strings.HasPrefix(loc2, "https://two.example.com/oauth/authorize?"))
+	assert.Contains(t, loc2, "client_id=id2")
+}
+
+func TestNew_CookieName(t *testing.T) {
+	c1 := New(WithPrefix("https://one.example.com"), WithClientID("id1", "s1"))
+	c2 := New(WithPrefix("https://one.example.com"), WithClientID("id2", "s2"))
+	c3 := New(WithPrefix("https://one.example.com"), WithClientID("id1", "s1"))
+
+	assert.Equal(t, CookieName("https://one.example.com", "id1"), c1.Cooking("v").Name)
+	assert.NotEqual(t, c1.Cooking("v").Name, c2.Cooking("v").Name)
+	assert.Equal(t, c1.Cooking("v").Name, c3.Cooking("v").Name, "stable across instances")
+	assert.True(t, strings.HasPrefix(c1.Cooking("v").Name, "_user_"))
+}
+
+func TestClient_RegisterStores(t *testing.T) {
+	c := Default()
+	ss, ts := c.states(), c.TokenStore()
+	defer func() {
+		RegisterStateStore(ss)
+		RegisterTokenStore(ts)
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 100 {
+			RegisterStateStore(newStateStore())
+			RegisterTokenStore(NewMemoryTokenStore())
+		}
+	}()
+	for range 100 {
+		_ = c.states()
+		_ = c.TokenStore()
+	}
+	<-done
+	assert.NotNil(t, c.TokenStore())
+}
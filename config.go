@@ -0,0 +1,327 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	auth "github.com/liut/simpauth"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the configuration of a Client, loaded from a file with LoadConfig,
+// or from the environment with ConfigFromEnv, the keys of environment are in comments.
+type Config struct {
+	// Prefix is the base URL of the provider, default https://staffio.work (OAUTH_PREFIX)
+	Prefix string `json:"prefix" yaml:"prefix" toml:"prefix"`
+	// ClientID and ClientSecret are required (OAUTH_CLIENT_ID, OAUTH_CLIENT_SECRET)
+	ClientID     string `json:"client_id" yaml:"client_id" toml:"client_id"`
+	ClientSecret string `json:"client_secret" yaml:"client_secret" toml:"client_secret"`
+	// RedirectURL is the callback URL, a path is resolved with the request host,
+	// default /auth/callback (OAUTH_REDIRECT_URL)
+	RedirectURL string `json:"redirect_url" yaml:"redirect_url" toml:"redirect_url"`
+	// Scopes of the authorization request (OAUTH_SCOPES, comma separated)
+	Scopes []string `json:"scopes" yaml:"scopes" toml:"scopes"`
+	// AuthorizeURI, TokenURI and InfoURI are the endpoints, relative ones are joined with the prefix,
+	// default authorize, token and info/me (OAUTH_URI_AUTHORIZE, OAUTH_URI_TOKEN, OAUTH_URI_INFO)
+	AuthorizeURI string `json:"uri_authorize" yaml:"uri_authorize" toml:"uri_authorize"`
+	TokenURI     string `json:"uri_token" yaml:"uri_token" toml:"uri_token"`
+	InfoURI      string `json:"uri_info" yaml:"uri_info" toml:"uri_info"`
+	// OIDC enables the OpenID Connect mode with discovery (OAUTH_OIDC)
+	OIDC bool `json:"oidc" yaml:"oidc" toml:"oidc"`
+	// StateSecret enables the encrypted multi-tab state cookie, at least 16 chars (OAUTH_STATE_SECRET)
+	StateSecret string `json:"state_secret" yaml:"state_secret" toml:"state_secret"`
+	// AdminPath and LoginPath, default are the package AdminPath and LoginPath
+	AdminPath string `json:"admin_path" yaml:"admin_path" toml:"admin_path"`
+	LoginPath string `json:"login_path" yaml:"login_path" toml:"login_path"`
+	// AllowedHosts are the hosts allowed for return_to besides the request host
+	AllowedHosts []string `json:"allowed_hosts" yaml:"allowed_hosts" toml:"allowed_hosts"`
+	// TrustedProxies are IPs or CIDRs whose X-Forwarded-For is trusted (OAUTH_TRUSTED_PROXIES, comma separated)
+	TrustedProxies []string `json:"trusted_proxies" yaml:"trusted_proxies" toml:"trusted_proxies"`
+	// Retries of calls to the provider, 0 disables the retry transport (OAUTH_RETRIES)
+	Retries int `json:"retries" yaml:"retries" toml:"retries"`
+	// RequireRoles is the role expression required in the callback, like "admin || (ops && oncall)"
+	// (OAUTH_REQUIRE_ROLES)
+	RequireRoles string `json:"require_roles" yaml:"require_roles" toml:"require_roles"`
+	// RoleHierarchy maps a role to the roles it implies
+	RoleHierarchy RoleHierarchy `json:"role_hierarchy" yaml:"role_hierarchy" toml:"role_hierarchy"`
+
+	// TLS of the http client to the provider
+	// (OAUTH_CA_FILE, OAUTH_CERT_FILE, OAUTH_KEY_FILE, OAUTH_TLS_MIN_VERSION, OAUTH_INSECURE)
+	CAFile        string `json:"ca_file" yaml:"ca_file" toml:"ca_file"`
+	CertFile      string `json:"cert_file" yaml:"cert_file" toml:"cert_file"`
+	KeyFile       string `json:"key_file" yaml:"key_file" toml:"key_file"`
+	TLSMinVersion string `json:"tls_min_version" yaml:"tls_min_version" toml:"tls_min_version"`
+	Insecure      bool   `json:"insecure" yaml:"insecure" toml:"insecure"`
+
+	// Cookie of the local user session, a new Authorizer is built if CookieName is set
+	// (AUTH_COOKIE_NAME, AUTH_COOKIE_PATH, AUTH_COOKIE_DOMAIN)
+	CookieName   string `json:"cookie_name" yaml:"cookie_name" toml:"cookie_name"`
+	CookiePath   string `json:"cookie_path" yaml:"cookie_path" toml:"cookie_path"`
+	CookieDomain string `json:"cookie_domain" yaml:"cookie_domain" toml:"cookie_domain"`
+}
+
+// ConfigError is a validation error of a Config field
+type ConfigError struct {
+	Field  string
+	Reason string
+}
+
+func (e *ConfigError) Error() string {
+	return "config " + e.Field + ": " + e.Reason
+}
+
+// DefaultConfig returns a Config with the defaults
+func DefaultConfig() Config {
+	return Config{
+		Prefix:       "https://staffio.work",
+		RedirectURL:  "/auth/callback",
+		AuthorizeURI: "authorize",
+		TokenURI:     "token",
+		InfoURI:      "info/me",
+	}
+}
+
+// ConfigFromEnv returns the defaults overridden by the environment
+func ConfigFromEnv() Config {
+	cfg := DefaultConfig()
+	cfg.LoadEnv()
+	return cfg
+}
+
+// LoadConfig reads the defaults overridden by a YAML, TOML or JSON file, by the extension of path.
+// Unknown keys are errors.
+func LoadConfig(path string) (Config, error) {
+	cfg := DefaultConfig()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(&cfg)
+	case ".toml":
+		var md toml.MetaData
+		if md, err = toml.Decode(string(data), &cfg); err == nil {
+			if keys := md.Undecoded(); len(keys) > 0 {
+				err = fmt.Errorf("unknown keys %v", keys)
+			}
+		}
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&cfg)
+	default:
+		return cfg, fmt.Errorf("unsupported config file %q", ext)
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("load config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// LoadEnv overrides the fields with the environment variables which are set
+func (cfg *Config) LoadEnv() {
+	setStr := func(p *string, key string) {
+		if v := envOrP(key, ""); len(v) > 0 {
+			*p = v
+		}
+	}
+	setStr(&cfg.Prefix, "PREFIX")
+	setStr(&cfg.ClientID, "CLIENT_ID")
+	setStr(&cfg.ClientSecret, "CLIENT_SECRET")
+	setStr(&cfg.RedirectURL, "REDIRECT_URL")
+	setStr(&cfg.AuthorizeURI, "URI_AUTHORIZE")
+	setStr(&cfg.TokenURI, "URI_TOKEN")
+	setStr(&cfg.InfoURI, "URI_INFO")
+	setStr(&cfg.StateSecret, "STATE_SECRET")
+	setStr(&cfg.CAFile, "CA_FILE")
+	setStr(&cfg.CertFile, "CERT_FILE")
+	setStr(&cfg.KeyFile, "KEY_FILE")
+	setStr(&cfg.TLSMinVersion, "TLS_MIN_VERSION")
+	setStr(&cfg.RequireRoles, "REQUIRE_ROLES")
+	if v := envOrP("SCOPES", ""); len(v) > 0 {
+		cfg.Scopes = strings.Split(v, ",")
+	}
+	if v := envOrP("TRUSTED_PROXIES", ""); len(v) > 0 {
+		cfg.TrustedProxies = strings.Split(v, ",")
+	}
+	if v, err := strconv.ParseBool(envOrP("OIDC", "")); err == nil {
+		cfg.OIDC = v
+	}
+	if v, err := strconv.ParseBool(envOrP("INSECURE", "")); err == nil {
+		cfg.Insecure = v
+	}
+	if v, err := strconv.Atoi(envOrP("RETRIES", "")); err == nil {
+		cfg.Retries = v
+	}
+	cfg.CookieName = envOr("AUTH_COOKIE_NAME", cfg.CookieName)
+	cfg.CookiePath = envOr("AUTH_COOKIE_PATH", cfg.CookiePath)
+	cfg.CookieDomain = envOr("AUTH_COOKIE_DOMAIN", cfg.CookieDomain)
+}
+
+// Validate checks the fields, returns the ConfigErrors joined
+func (cfg Config) Validate() error {
+	var errs []error
+	fail := func(field, reason string) {
+		errs = append(errs, &ConfigError{Field: field, Reason: reason})
+	}
+	if u, err := url.Parse(cfg.Prefix); err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		fail("prefix", "must be an absolute http(s) URL")
+	}
+	if len(cfg.ClientID) == 0 {
+		fail("client_id", "is required")
+	}
+	if len(cfg.ClientSecret) == 0 {
+		fail("client_secret", "is required")
+	}
+	if len(cfg.StateSecret) > 0 && len(cfg.StateSecret) < 16 {
+		fail("state_secret", "must be at least 16 chars")
+	}
+	if len(cfg.TLSMinVersion) > 0 {
+		if _, err := ParseTLSVersion(cfg.TLSMinVersion); err != nil {
+			fail("tls_min_version", "must be 1.2 or 1.3")
+		}
+	}
+	if (len(cfg.CertFile) > 0) != (len(cfg.KeyFile) > 0) {
+		fail("cert_file", "both cert_file and key_file are required")
+	}
+	for _, s := range cfg.TrustedProxies {
+		s = strings.TrimSpace(s)
+		if _, err := netip.ParsePrefix(s); err != nil {
+			if _, err = netip.ParseAddr(s); err != nil {
+				fail("trusted_proxies", fmt.Sprintf("invalid IP or CIDR %q", s))
+			}
+		}
+	}
+	if cfg.Retries < 0 {
+		fail("retries", "must not be negative")
+	}
+	if len(cfg.RequireRoles) > 0 {
+		if _, err := ParseRoleExpr(cfg.RequireRoles); err != nil {
+			fail("require_roles", err.Error())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// TLSOptions returns the TLS fields as TLSOptions
+func (cfg Config) TLSOptions() (o TLSOptions, err error) {
+	o = TLSOptions{CAFile: cfg.CAFile, CertFile: cfg.CertFile, KeyFile: cfg.KeyFile, Insecure: cfg.Insecure}
+	if len(cfg.TLSMinVersion) > 0 {
+		o.MinVersion, err = ParseTLSVersion(cfg.TLSMinVersion)
+	}
+	return
+}
+
+// Options validates and converts the config into Options
+func (cfg Config) Options() ([]Option, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg.options()
+}
+
+// options converts the config into Options without validation
+func (cfg Config) options() ([]Option, error) {
+	opts := []Option{
+		WithPrefix(cfg.Prefix),
+		WithEndpoints(cfg.AuthorizeURI, cfg.TokenURI, cfg.InfoURI),
+		WithRedirectURL(cfg.RedirectURL),
+		WithPaths(cfg.AdminPath, cfg.LoginPath),
+	}
+	if len(cfg.ClientID) > 0 && len(cfg.ClientSecret) > 0 {
+		opts = append(opts, WithClientID(cfg.ClientID, cfg.ClientSecret))
+	}
+	if len(cfg.Scopes) > 0 {
+		opts = append(opts, WithScopes(cfg.Scopes...))
+	}
+	if cfg.OIDC {
+		opts = append(opts, WithOIDC())
+	}
+	if len(cfg.AllowedHosts) > 0 {
+		opts = append(opts, WithAllowedHosts(cfg.AllowedHosts...))
+	}
+	if len(cfg.TrustedProxies) > 0 {
+		opts = append(opts, WithTrustedProxies(cfg.TrustedProxies...))
+	}
+	if cfg.Retries > 0 {
+		opts = append(opts, WithRetry(RetryPolicy{MaxRetries: cfg.Retries}))
+	}
+	if len(cfg.RoleHierarchy) > 0 {
+		opts = append(opts, WithRoleHierarchy(cfg.RoleHierarchy))
+	}
+	if len(cfg.CookieName) > 0 {
+		opts = append(opts, WithAuthorizer(auth.New(auth.WithCookie(cfg.CookieName, cfg.CookiePath, cfg.CookieDomain))))
+	}
+
+	var errs []error
+	if len(cfg.RequireRoles) > 0 {
+		if e, err := ParseRoleExpr(cfg.RequireRoles); err != nil {
+			errs = append(errs, err)
+		} else {
+			opts = append(opts, WithRequiredRoles(e))
+		}
+	}
+	if len(cfg.StateSecret) > 0 {
+		if ss, err := NewCookieStateStore(cfg.StateSecret); err != nil {
+			errs = append(errs, err)
+		} else {
+			opts = append(opts, WithStateStore(ss))
+		}
+	}
+	if to, err := cfg.TLSOptions(); err != nil {
+		errs = append(errs, err)
+	} else if to != (TLSOptions{}) {
+		if tc, err := to.Config(); err != nil {
+			errs = append(errs, err)
+		} else {
+			opts = append(opts, WithHTTPClient(newHTTPClient(tc)))
+		}
+	}
+	return opts, errors.Join(errs...)
+}
+
+// NewFromConfig validates the config and returns a Client, discovers the provider in OIDC mode.
+// The extra opts are applied after the config.
+func NewFromConfig(ctx context.Context, cfg Config, opts ...Option) (*Client, error) {
+	cos, err := cfg.Options()
+	if err != nil {
+		return nil, err
+	}
+	c := New(append(cos, opts...)...)
+	if c.oidc {
+		if err = c.Discover(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// envOptions returns the options of the default client from the environment, with the error
+// of the validation and of building options, the valid options are returned anyway.
+func envOptions() ([]Option, error) {
+	cfg := ConfigFromEnv()
+	verr := cfg.Validate()
+	opts, err := cfg.options()
+	// the default client shares the default authorizer of simpauth, configured in init
+	opts = append(opts, WithAuthorizer(auth.Default()))
+	if verr != nil {
+		err = verr
+	}
+	if err != nil {
+		return opts, fmt.Errorf("config from environment: %w", err)
+	}
+	return opts, nil
+}
@@ -0,0 +1,104 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"c.yaml": "prefix: https://sso.example.com\nclient_id: cid\nclient_secret: secret\nscopes: [openid, staff]\nretries: 2\nrequire_roles: admin || ops\nrole_hierarchy:\n  admin: [editor]\n",
+		"c.toml": "prefix = \"https://sso.example.com\"\nclient_id = \"cid\"\nclient_secret = \"secret\"\nscopes = [\"openid\", \"staff\"]\nretries = 2\nrequire_roles = \"admin || ops\"\n[role_hierarchy]\nadmin = [\"editor\"]\n",
+		"c.json": `{"prefix":"https://sso.example.com","client_id":"cid","client_secret":"secret","scopes":["openid","staff"],"retries":2,"require_roles":"admin || ops","role_hierarchy":{"admin":["editor"]}}`,
+	}
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600))
+	}
+	tests := []struct {
+		name string
+		file string
+	}{
+		{"YAML 文件", "c.yaml"},
+		{"TOML 文件", "c.toml"},
+		{"JSON 文件", "c.json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := LoadConfig(filepath.Join(dir, tt.file))
+			require.NoError(t, err)
+			assert.Equal(t, "https://sso.example.com", cfg.Prefix)
+			assert.Equal(t, "cid", cfg.ClientID)
+			assert.Equal(t, []string{"openid", "staff"}, cfg.Scopes)
+			assert.Equal(t, 2, cfg.Retries)
+			assert.Equal(t, "admin || ops", cfg.RequireRoles)
+			assert.Equal(t, RoleHierarchy{"admin": {"editor"}}, cfg.RoleHierarchy)
+			assert.Equal(t, "info/me", cfg.InfoURI, "default kept")
+			assert.NoError(t, cfg.Validate())
+		})
+	}
+
+	bad := map[string]string{
+		"u.yaml": "prefx: https://sso.example.com\n",
+		"u.toml": "prefx = \"x\"\n",
+		"u.json": `{"prefx":"x"}`,
+		"u.ini":  "",
+	}
+	for name, content := range bad {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		_, err := LoadConfig(path)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Prefix = "sso.example.com"
+	cfg.StateSecret = "short"
+	cfg.TLSMinVersion = "1.1"
+	cfg.CertFile = "cert.pem"
+	cfg.TrustedProxies = []string{"10.0.0.0/8", "nope"}
+	cfg.RequireRoles = "admin ||"
+	err := cfg.Validate()
+	require.Error(t, err)
+
+	var fields []string
+	for _, e := range err.(interface{ Unwrap() []error }).Unwrap() {
+		var ce *ConfigError
+		require.True(t, errors.As(e, &ce))
+		fields = append(fields, ce.Field)
+	}
+	assert.Equal(t, []string{"prefix", "client_id", "client_secret", "state_secret", "tls_min_version",
+		"cert_file", "trusted_proxies", "require_roles"}, fields)
+
+	_, err = NewFromConfig(context.Background(), cfg)
+	assert.Error(t, err)
+}
+
+func TestConfig_Env(t *testing.T) {
+	t.Setenv("OAUTH_PREFIX", "https://env.example.com")
+	t.Setenv("STAFFIO_CLIENT_ID", "env-id")
+	t.Setenv("OAUTH_CLIENT_SECRET", "env-secret")
+	t.Setenv("OAUTH_SCOPES", "openid,staff")
+	t.Setenv("AUTH_COOKIE_NAME", "_sess")
+
+	cfg := DefaultConfig()
+	cfg.ClientID = "file-id"
+	cfg.LoadEnv()
+	assert.Equal(t, "https://env.example.com", cfg.Prefix)
+	assert.Equal(t, "env-id", cfg.ClientID, "env overrides")
+	assert.Equal(t, []string{"openid", "staff"}, cfg.Scopes)
+
+	c, err := NewFromConfig(context.Background(), cfg, WithPaths("/dash/", ""))
+	require.NoError(t, err)
+	assert.Equal(t, "https://env.example.com/token", c.Config().Endpoint.TokenURL)
+	assert.Equal(t, "env-id", c.Config().ClientID)
+	assert.Equal(t, "/dash/", c.AdminPath())
+}
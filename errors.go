@@ -0,0 +1,144 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+
+	"golang.org/x/oauth2"
+)
+
+// OAuthError is an error with the RFC 6749 error code, description and URI,
+// and the HTTP status to respond. The wrapped cause is logged only, never rendered.
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+	URI         string `json:"error_uri,omitempty"`
+	Status      int    `json:"-"`
+	Err         error  `json:"-"`
+}
+
+// sentinel errors, match them with errors.Is
+var (
+	ErrInvalidGrant   = &OAuthError{Code: "invalid_grant"}
+	ErrAccessDenied   = &OAuthError{Code: "access_denied"}
+	ErrInvalidState   = &OAuthError{Code: "invalid_state"}
+	ErrMissingRole    = &OAuthError{Code: "missing_role"}
+	ErrInvalidRequest = &OAuthError{Code: "invalid_request"}
+	ErrServerError    = &OAuthError{Code: "server_error"}
+)
+
+// NewOAuthError returns an OAuthError
+func NewOAuthError(code, desc string, status int) *OAuthError {
+	return &OAuthError{Code: code, Description: desc, Status: status}
+}
+
+func (e *OAuthError) Error() string {
+	s := e.Code
+	if len(e.Description) > 0 {
+		s += ": " + e.Description
+	}
+	if e.Err != nil {
+		s += " (" + e.Err.Error() + ")"
+	}
+	return s
+}
+
+func (e *OAuthError) Unwrap() error {
+	return e.Err
+}
+
+// Is matches an OAuthError with the same code
+func (e *OAuthError) Is(target error) bool {
+	t, ok := target.(*OAuthError)
+	return ok && t.Code == e.Code
+}
+
+// GetStatus returns the HTTP status, default is 400
+func (e *OAuthError) GetStatus() int {
+	if e.Status > 0 {
+		return e.Status
+	}
+	return http.StatusBadRequest
+}
+
+// AsOAuthError converts an error to OAuthError, including errors of token exchange,
+// ErrNoToken becomes unauthorized with 401, an unknown error becomes server_error without description.
+func AsOAuthError(err error) *OAuthError {
+	var oe *OAuthError
+	if errors.As(err, &oe) {
+		return oe
+	}
+	var re *oauth2.RetrieveError
+	if errors.As(err, &re) {
+		oe = &OAuthError{Code: re.ErrorCode, Description: re.ErrorDescription, URI: re.ErrorURI, Err: err}
+		if len(oe.Code) == 0 {
+			oe.Code, oe.Status = ErrServerError.Code, http.StatusBadGateway
+		} else if re.Response != nil && re.Response.StatusCode >= 500 {
+			oe.Status = http.StatusBadGateway
+		}
+		return oe
+	}
+	if errors.Is(err, ErrNoToken) {
+		return &OAuthError{Code: "unauthorized", Status: http.StatusUnauthorized, Err: err}
+	}
+	var rse *ResponseError
+	if errors.As(err, &rse) {
+		return &OAuthError{Code: ErrServerError.Code, Status: http.StatusBadGateway, Err: err}
+	}
+	return &OAuthError{Code: ErrServerError.Code, Status: http.StatusInternalServerError, Err: err}
+}
+
+// callbackError returns the error returned by the provider in the callback query, nil if none
+func callbackError(r *http.Request) *OAuthError {
+	code := r.FormValue("error")
+	if len(code) == 0 {
+		return nil
+	}
+	oe := &OAuthError{Code: code, Description: r.FormValue("error_description"), URI: r.FormValue("error_uri")}
+	if code == ErrAccessDenied.Code {
+		oe.Status = http.StatusForbidden
+	}
+	return oe
+}
+
+// ErrorHandler renders an error of the auth flow
+type ErrorHandler func(w http.ResponseWriter, r *http.Request, err error)
+
+// WithErrorHandler sets the ErrorHandler of the client, default is WriteError
+func WithErrorHandler(eh ErrorHandler) Option {
+	return func(c *Client) {
+		c.errorHandler = eh
+	}
+}
+
+func (c *Client) handleError(w http.ResponseWriter, r *http.Request, err error) {
+	if c.errorHandler != nil {
+		c.errorHandler(w, r, err)
+		return
+	}
+	WriteError(w, r, err)
+}
+
+// ErrorTemplate is the HTML template of WriteError, executed with *OAuthError
+var ErrorTemplate = template.Must(template.New("error").Parse(`<html><title>{{.Code}}</title>` +
+	`<body style='padding: 2em;'><h3>{{.Code}}</h3>{{with .Description}}<p>{{.}}</p>{{end}}` +
+	`{{with .URI}}<p><a href="{{.}}">more</a></p>{{end}}</body></html>`))
+
+// WriteError is the default ErrorHandler, renders JSON for AJAX requests and HTML with ErrorTemplate otherwise
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	oe := AsOAuthError(err)
+	slog.Info("auth error", "code", oe.Code, "err", err, "uri", r.RequestURI)
+	w.Header().Set("Cache-Control", "no-store")
+	if IsAjax(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(oe.GetStatus())
+		_ = json.NewEncoder(w).Encode(oe)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(oe.GetStatus())
+	_ = ErrorTemplate.Execute(w, oe)
+}
@@ -0,0 +1,73 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestOAuthError_Is(t *testing.T) {
+	err := error(&OAuthError{Code: "missing_role", Status: http.StatusForbidden, Err: ErrNoRole})
+	assert.ErrorIs(t, err, ErrMissingRole)
+	assert.ErrorIs(t, err, ErrNoRole)
+	assert.NotErrorIs(t, err, ErrInvalidGrant)
+
+	re := &oauth2.RetrieveError{Response: &http.Response{StatusCode: 400}, ErrorCode: "invalid_grant", ErrorDescription: "code expired"}
+	oe := AsOAuthError(re)
+	assert.ErrorIs(t, oe, ErrInvalidGrant)
+	assert.Equal(t, "code expired", oe.Description)
+	assert.Equal(t, http.StatusBadRequest, oe.GetStatus())
+
+	oe = AsOAuthError(errors.New("dial tcp: connection refused"))
+	assert.ErrorIs(t, oe, ErrServerError)
+	assert.Empty(t, oe.Description)
+	assert.Equal(t, http.StatusInternalServerError, oe.GetStatus())
+
+	oe = AsOAuthError(fmt.Errorf("session: %w", ErrNoToken))
+	assert.Equal(t, "unauthorized", oe.Code)
+	assert.Equal(t, http.StatusUnauthorized, oe.GetStatus())
+
+	assert.ErrorIs(t, InfoError{ErrCode: "access_denied"}.GetError(), ErrAccessDenied)
+}
+
+func TestCodeCallback_Errors(t *testing.T) {
+	var got error
+	c := New(WithPrefix("http://127.0.0.1:1"), WithErrorHandler(func(w http.ResponseWriter, r *http.Request, err error) {
+		got = err
+		WriteError(w, r, err)
+	}))
+	h := c.AuthCodeCallback()
+
+	// invalid state, rendered as HTML without the state value
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/callback?state=%3Cscript%3E&code=x", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Header().Get("Content-Type"), "text/html")
+	assert.Contains(t, rec.Body.String(), "invalid_state")
+	assert.NotContains(t, rec.Body.String(), "<script>")
+
+	// error returned by the provider, rendered as JSON for AJAX
+	rec = httptest.NewRecorder()
+	authURL := c.LoginStart(rec, httptest.NewRequest(http.MethodGet, "/auth/login", nil))
+	u, _ := url.Parse(authURL)
+	q := url.Values{"state": {u.Query().Get("state")}, "error": {"access_denied"}, "error_description": {"user cancelled"}}
+	r := requestWithCookies(rec)
+	r.URL.RawQuery = q.Encode()
+	r.Header.Set("Accept", "application/json")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	assert.ErrorIs(t, got, ErrAccessDenied)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	var oe OAuthError
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&oe))
+	assert.Equal(t, "access_denied", oe.Code)
+	assert.Equal(t, "user cancelled", oe.Description)
+}
@@ -0,0 +1,237 @@
+package client
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// ErrCacheMiss is returned by an InfoCache when the key is not found or expired
+var ErrCacheMiss = errors.New("info not found in cache")
+
+// InfoCacheTTL is the max time an InfoToken is cached, so that role changes take effect
+var InfoCacheTTL = 5 * time.Minute
+
+// InfoCache caches InfoTokens of RequestInfoToken, keyed by the hash of access tokens,
+// with "|" and the roles requested appended if any.
+// Implement it with Redis or the like to share between instances.
+// InvalidateInfo knows only the roles requested in this process, so a shared InfoCache
+// should also drop the keys prefixed by the first key passed to Delete and "|".
+type InfoCache interface {
+	Get(ctx context.Context, key string) (*InfoToken, error)
+	Set(ctx context.Context, key string, it *InfoToken, ttl time.Duration) error
+	Delete(ctx context.Context, keys ...string) error
+}
+
+// WithInfoCache sets the InfoCache of RequestInfoToken
+func WithInfoCache(ic InfoCache) Option {
+	return func(c *Client) {
+		c.infoCache = ic
+	}
+}
+
+// InvalidateInfo drops the cached InfoTokens of the access token in the default client
+func InvalidateInfo(ctx context.Context, accessToken string) error {
+	return Default().InvalidateInfo(ctx, accessToken)
+}
+
+// InvalidateInfo drops the cached InfoTokens of the access token, with all roles requested by this client.
+// The keys of roles requested only by other instances are left to the InfoCache, see InfoCache.
+func (c *Client) InvalidateInfo(ctx context.Context, accessToken string) error {
+	if len(accessToken) == 0 {
+		return nil
+	}
+	prefix := infoCacheKey(accessToken, nil)
+	c.bearers.forget(prefix)
+	if c.infoCache == nil {
+		return nil
+	}
+	c.infoMu.Lock()
+	keys := make([]string, 0, len(c.infoRoles)+1)
+	keys = append(keys, prefix)
+	for rs := range c.infoRoles {
+		keys = append(keys, prefix+"|"+rs)
+	}
+	c.infoMu.Unlock()
+	return c.infoCache.Delete(ctx, keys...)
+}
+
+// cachedInfoToken looks up the cache, and requests the provider once for concurrent callers of the same token
+func (c *Client) cachedInfoToken(ctx context.Context, tok *oauth2.Token, roles []string) (*InfoToken, error) {
+	key := infoCacheKey(tok.AccessToken, roles)
+	if it, err := c.infoCache.Get(ctx, key); err == nil {
+		return it, nil
+	}
+	if len(roles) > 0 {
+		c.infoMu.Lock()
+		if c.infoRoles == nil {
+			c.infoRoles = make(map[string]struct{})
+		}
+		c.infoRoles[key[strings.IndexByte(key, '|')+1:]] = struct{}{}
+		c.infoMu.Unlock()
+	}
+	// the request is shared by the callers, so it must not be canceled with the first one
+	fctx := context.WithoutCancel(ctx)
+	v, err, _ := c.infoGroup.Do(key, func() (any, error) {
+		it, err := c.requestInfoToken(fctx, tok, roles...)
+		if err != nil {
+			return nil, err
+		}
+		ttl := InfoCacheTTL
+		if !it.Expiry.IsZero() {
+			ttl = min(ttl, time.Until(it.Expiry))
+		}
+		if ttl > 0 {
+			_ = c.infoCache.Set(fctx, key, it, ttl)
+		}
+		return it, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*InfoToken).clone(), nil
+}
+
+func infoCacheKey(accessToken string, roles []string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	key := hex.EncodeToString(sum[:])
+	if len(roles) > 0 {
+		rs := slices.Clone(roles)
+		slices.Sort(rs)
+		key += "|" + strings.Join(rs, ",")
+	}
+	return key
+}
+
+// MemoryInfoCache is an in-memory LRU InfoCache
+type MemoryInfoCache struct {
+	mu    sync.Mutex
+	size  int
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type infoEntry struct {
+	key     string
+	it      InfoToken
+	expires time.Time
+}
+
+// NewMemoryInfoCache returns a MemoryInfoCache holds at most size entries, default 1000
+func NewMemoryInfoCache(size int) *MemoryInfoCache {
+	if size <= 0 {
+		size = 1000
+	}
+	return &MemoryInfoCache{size: size, ll: list.New(), items: make(map[string]*list.Element)}
+}
+
+// Get returns a copy of the cached InfoToken
+func (mc *MemoryInfoCache) Get(ctx context.Context, key string) (*InfoToken, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	el, ok := mc.items[key]
+	if !ok {
+		return nil, ErrCacheMiss
+	}
+	e := el.Value.(*infoEntry)
+	if time.Now().After(e.expires) {
+		mc.ll.Remove(el)
+		delete(mc.items, key)
+		return nil, ErrCacheMiss
+	}
+	mc.ll.MoveToFront(el)
+	return e.it.clone(), nil
+}
+
+// Set caches a copy of the InfoToken, evicts the least recently used one if full
+func (mc *MemoryInfoCache) Set(ctx context.Context, key string, it *InfoToken, ttl time.Duration) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	e := &infoEntry{key: key, it: *it.clone(), expires: time.Now().Add(ttl)}
+	if el, ok := mc.items[key]; ok {
+		el.Value = e
+		mc.ll.MoveToFront(el)
+		return nil
+	}
+	mc.items[key] = mc.ll.PushFront(e)
+	for mc.ll.Len() > mc.size {
+		el := mc.ll.Back()
+		mc.ll.Remove(el)
+		delete(mc.items, el.Value.(*infoEntry).key)
+	}
+	return nil
+}
+
+// Delete drops the keys
+func (mc *MemoryInfoCache) Delete(ctx context.Context, keys ...string) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	for _, key := range keys {
+		if el, ok := mc.items[key]; ok {
+			mc.ll.Remove(el)
+			delete(mc.items, key)
+		}
+	}
+	return nil
+}
+
+// Len returns the number of entries, including expired ones not evicted yet
+func (mc *MemoryInfoCache) Len() int {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return mc.ll.Len()
+}
+
+func (it *InfoToken) clone() *InfoToken {
+	cp := *it
+	if it.User != nil {
+		u := *it.User
+		cp.User = &u
+	}
+	if it.Me != nil {
+		me := *it.Me
+		cp.Me = &me
+	}
+	if cp.User != nil {
+		cp.User.Roles = slices.Clone(it.User.Roles)
+	}
+	cp.Roles = slices.Clone(it.Roles)
+	if it.Meta != nil {
+		cp.Meta = deepCopy(map[string]any(it.Meta)).(map[string]any)
+	}
+	if it.Extra != nil {
+		cp.Extra = deepCopy(it.Extra).(map[string]any)
+	}
+	return &cp
+}
+
+// deepCopy copies the maps and slices decoded from JSON, other values are shared
+func deepCopy(v any) any {
+	switch z := v.(type) {
+	case map[string]any:
+		m := make(map[string]any, len(z))
+		for k, e := range z {
+			m[k] = deepCopy(e)
+		}
+		return m
+	case Meta:
+		return Meta(deepCopy(map[string]any(z)).(map[string]any))
+	case []any:
+		a := make([]any, len(z))
+		for i, e := range z {
+			a[i] = deepCopy(e)
+		}
+		return a
+	case []string:
+		return slices.Clone(z)
+	}
+	return v
+}
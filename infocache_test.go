@@ -0,0 +1,120 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestMemoryInfoCache(t *testing.T) {
+	ctx := context.Background()
+	mc := NewMemoryInfoCache(2)
+	_, err := mc.Get(ctx, "a")
+	assert.ErrorIs(t, err, ErrCacheMiss)
+
+	require.NoError(t, mc.Set(ctx, "a", &InfoToken{AccessToken: "a"}, time.Minute))
+	require.NoError(t, mc.Set(ctx, "b", &InfoToken{AccessToken: "b"}, time.Minute))
+	_, err = mc.Get(ctx, "a") // a is recently used
+	require.NoError(t, err)
+	require.NoError(t, mc.Set(ctx, "c", &InfoToken{AccessToken: "c"}, time.Minute))
+	assert.Equal(t, 2, mc.Len())
+	_, err = mc.Get(ctx, "b")
+	assert.ErrorIs(t, err, ErrCacheMiss, "evicted")
+
+	it, err := mc.Get(ctx, "a")
+	require.NoError(t, err)
+	it.AccessToken = "changed"
+	it, _ = mc.Get(ctx, "a")
+	assert.Equal(t, "a", it.AccessToken, "copy returned")
+
+	meta := Meta{"org": map[string]any{"dept": "dev"}, "tags": []any{"a"}}
+	require.NoError(t, mc.Set(ctx, "m", &InfoToken{Meta: meta}, time.Minute))
+	meta["org"].(map[string]any)["dept"] = "ops"
+	it, _ = mc.Get(ctx, "m")
+	it.Meta["tags"].([]any)[0] = "b"
+	it, _ = mc.Get(ctx, "m")
+	assert.Equal(t, "dev", it.Meta.GetStr("org.dept"), "meta copied deeply")
+	assert.Equal(t, []any{"a"}, it.Meta["tags"])
+
+	require.NoError(t, mc.Set(ctx, "d", &InfoToken{}, -time.Second))
+	_, err = mc.Get(ctx, "d")
+	assert.ErrorIs(t, err, ErrCacheMiss, "expired")
+
+	require.NoError(t, mc.Delete(ctx, "a", "c", "m"))
+	assert.Equal(t, 0, mc.Len())
+}
+
+func TestClient_InfoCache(t *testing.T) {
+	var hits atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "at", "expires_in": 3600,
+			"me": map[string]string{"uid": "alice"}, "group": []string{"admin"},
+		})
+	}))
+	defer srv.Close()
+
+	c := New(WithPrefix(srv.URL), WithEndpoints("", "", "/info/me"), WithInfoCache(NewMemoryInfoCache(0)))
+	tok := &oauth2.Token{AccessToken: "at", TokenType: "Bearer"}
+	ctx := context.Background()
+
+	// concurrent lookups of the same token are deduplicated, each caller gets its own copy,
+	// and canceling the caller who started the request fails none of them
+	var wg sync.WaitGroup
+	results := make([]*InfoToken, 5)
+	first, cancel := context.WithCancel(ctx)
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cctx := ctx
+			if i == 0 {
+				cctx = first
+			}
+			it, err := c.RequestInfoToken(cctx, tok, "admin")
+			if assert.NoError(t, err) {
+				assert.True(t, it.HasRole("admin"))
+				results[i] = it
+			}
+		}()
+		if i == 0 {
+			require.Eventually(t, func() bool { return hits.Load() == 1 }, time.Second, time.Millisecond)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	close(release)
+	wg.Wait()
+	assert.EqualValues(t, 1, hits.Load())
+	for i := 1; i < len(results); i++ {
+		assert.NotSame(t, results[0], results[i])
+	}
+
+	_, err := c.RequestInfoToken(ctx, tok, "admin")
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, hits.Load(), "cached")
+
+	_, err = c.RequestInfoToken(ctx, tok)
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, hits.Load(), "other roles")
+
+	require.NoError(t, c.InvalidateInfo(ctx, tok.AccessToken))
+	_, err = c.RequestInfoToken(ctx, tok, "admin")
+	require.NoError(t, err)
+	_, err = c.RequestInfoToken(ctx, tok)
+	require.NoError(t, err)
+	assert.EqualValues(t, 4, hits.Load(), "invalidated")
+}
@@ -0,0 +1,330 @@
+package client
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// errors of ID token verification
+var (
+	ErrInvalidIDToken = errors.New("invalid id_token")
+	ErrNoIDToken      = errors.New("id_token not found in token response")
+)
+
+// clockSkew is the leeway of time based claims
+const clockSkew = time.Minute
+
+// Audience is the aud claim, which may be a string or an array in JSON
+type Audience []string
+
+// UnmarshalJSON implements json.Unmarshaler
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = Audience{s}
+		return nil
+	}
+	var ss []string
+	if err := json.Unmarshal(b, &ss); err != nil {
+		return err
+	}
+	*a = ss
+	return nil
+}
+
+// IDClaims is the claims of an OpenID Connect ID token or the userinfo response
+type IDClaims struct {
+	Issuer    string   `json:"iss,omitempty"`
+	Subject   string   `json:"sub"`
+	Audience  Audience `json:"aud,omitempty"`
+	Expiry    int64    `json:"exp,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	Nonce     string   `json:"nonce,omitempty"`
+	SessionID string   `json:"sid,omitempty"`
+
+	Name              string   `json:"name,omitempty"`
+	Nickname          string   `json:"nickname,omitempty"`
+	PreferredUsername string   `json:"preferred_username,omitempty"`
+	Picture           string   `json:"picture,omitempty"`
+	Email             string   `json:"email,omitempty"`
+	PhoneNumber       string   `json:"phone_number,omitempty"`
+	Roles             []string `json:"roles,omitempty"`
+	Groups            []string `json:"groups,omitempty"`
+}
+
+// GetRoles returns roles of claims, fallback to groups
+func (c *IDClaims) GetRoles() []string {
+	if len(c.Roles) > 0 {
+		return c.Roles
+	}
+	return c.Groups
+}
+
+// ToO2User builds an O2User from the claims
+func (c *IDClaims) ToO2User() *O2User {
+	user := &O2User{Sub: c.Subject, Email: c.Email, Phone: c.PhoneNumber}
+	user.OID = c.Subject
+	user.UID = c.PreferredUsername
+	if len(user.UID) == 0 {
+		user.UID = c.Subject
+	}
+	user.Name = c.Nickname
+	if len(user.Name) == 0 {
+		user.Name = c.Name
+	}
+	user.Avatar = c.Picture
+	user.Roles = c.GetRoles()
+	return user
+}
+
+// jsonWebKey is a public key in a JWK Set
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) { //nolint:staticcheck
+			return nil, errors.New("invalid ec point")
+		}
+		return pub, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid ed25519 key size")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// jwksRefetchInterval is the minimum interval of the JWK Set refetches for unknown kids,
+// which may come from unauthenticated requests, ex: back-channel logout
+const jwksRefetchInterval = 30 * time.Second
+
+// keySet keeps the JWK Set of the provider, refetches it when an unknown kid is met
+type keySet struct {
+	uri string
+	hc  *http.Client
+
+	mu      sync.RWMutex
+	keys    []jsonWebKey
+	fetched time.Time // the last fetch, successful or not
+
+	sf singleflight.Group
+}
+
+func (ks *keySet) fetch(ctx context.Context) error {
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	err := getJSON(ctx, ks.hc, ks.uri, &jwks)
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.fetched = time.Now()
+	if err != nil {
+		return err
+	}
+	ks.keys = jwks.Keys
+	return nil
+}
+
+// refetch fetches the JWK Set once at a time, and at most once in jwksRefetchInterval
+func (ks *keySet) refetch(ctx context.Context) error {
+	ks.mu.RLock()
+	fetched := ks.fetched
+	ks.mu.RUnlock()
+	if time.Since(fetched) < jwksRefetchInterval {
+		return nil
+	}
+	_, err, _ := ks.sf.Do("jwks", func() (any, error) {
+		ks.mu.RLock()
+		fetched := ks.fetched
+		ks.mu.RUnlock()
+		if time.Since(fetched) < jwksRefetchInterval {
+			return nil, nil
+		}
+		return nil, ks.fetch(context.WithoutCancel(ctx))
+	})
+	return err
+}
+
+func (ks *keySet) find(kid, kty string) (found []jsonWebKey) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	for _, k := range ks.keys {
+		if k.Kty == kty && (len(kid) == 0 || k.Kid == kid) && (len(k.Use) == 0 || k.Use == "sig") {
+			found = append(found, k)
+		}
+	}
+	return
+}
+
+func (ks *keySet) lookup(ctx context.Context, kid, kty string) ([]jsonWebKey, error) {
+	if found := ks.find(kid, kty); len(found) > 0 {
+		return found, nil
+	}
+	if err := ks.refetch(ctx); err != nil {
+		return nil, err
+	}
+	if found := ks.find(kid, kty); len(found) > 0 {
+		return found, nil
+	}
+	return nil, fmt.Errorf("%w: key %q not found", ErrInvalidIDToken, kid)
+}
+
+var algKeyTypes = map[string]string{
+	"RS256": "RSA",
+	"ES256": "EC",
+	"EdDSA": "OKP",
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed, sig []byte) bool {
+	switch alg {
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return false
+		}
+		h := sha256.Sum256(signed)
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, h[:], sig) == nil
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(sig) != 64 {
+			return false
+		}
+		h := sha256.Sum256(signed)
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(pub, h[:], r, s)
+	case "EdDSA":
+		pub, ok := key.(ed25519.PublicKey)
+		return ok && ed25519.Verify(pub, signed, sig)
+	}
+	return false
+}
+
+// verifyJWT checks the signature of a compact JWS with keys and unmarshals its payload into claims
+func (ks *keySet) verifyJWT(ctx context.Context, raw string, claims any) error {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("%w: malformed", ErrInvalidIDToken)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return fmt.Errorf("%w: header: %s", ErrInvalidIDToken, err)
+	}
+	kty, ok := algKeyTypes[header.Alg]
+	if !ok {
+		return fmt.Errorf("%w: unsupported alg %q", ErrInvalidIDToken, header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return fmt.Errorf("%w: signature: %s", ErrInvalidIDToken, err)
+	}
+	keys, err := ks.lookup(ctx, header.Kid, kty)
+	if err != nil {
+		return err
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := slices.ContainsFunc(keys, func(k jsonWebKey) bool {
+		pub, err := k.publicKey()
+		if err != nil {
+			slog.Info("invalid jwk", "kid", k.Kid, "err", err)
+			return false
+		}
+		return verifySignature(header.Alg, pub, signed, sig)
+	})
+	if !verified {
+		return fmt.Errorf("%w: bad signature", ErrInvalidIDToken)
+	}
+	if err = decodeSegment(parts[1], claims); err != nil {
+		return fmt.Errorf("%w: payload: %s", ErrInvalidIDToken, err)
+	}
+	return nil
+}
+
+func decodeSegment(seg string, obj any) error {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(seg, "="))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, obj)
+}
+
+// validate checks the registered claims of an ID token
+func (c *IDClaims) validate(issuer, clientID, nonce string, now time.Time) error {
+	if c.Issuer != issuer {
+		return fmt.Errorf("%w: issuer %q mismatch", ErrInvalidIDToken, c.Issuer)
+	}
+	if !slices.Contains(c.Audience, clientID) {
+		return fmt.Errorf("%w: audience %v mismatch", ErrInvalidIDToken, c.Audience)
+	}
+	if c.Expiry == 0 || now.After(time.Unix(c.Expiry, 0).Add(clockSkew)) {
+		return fmt.Errorf("%w: expired", ErrInvalidIDToken)
+	}
+	if c.IssuedAt > 0 && time.Unix(c.IssuedAt, 0).After(now.Add(clockSkew)) {
+		return fmt.Errorf("%w: issued in the future", ErrInvalidIDToken)
+	}
+	if len(nonce) > 0 && c.Nonce != nonce {
+		return fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+	return nil
+}
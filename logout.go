@@ -0,0 +1,340 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	auth "github.com/liut/simpauth"
+)
+
+const backChannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+
+// ErrSessionRevoked is returned when the session was logged out by the provider
+var ErrSessionRevoked = errors.New("the session was logged out")
+
+// WithRevocationURI sets the RFC 7009 revocation endpoint, relative one is joined with the prefix
+func WithRevocationURI(uri string) Option {
+	return func(c *Client) {
+		c.revokeURI = uri
+	}
+}
+
+// WithEndSessionURI sets the end_session endpoint of the provider for RP-initiated logout
+func WithEndSessionURI(uri string) Option {
+	return func(c *Client) {
+		c.endSessionURI = uri
+	}
+}
+
+// WithPostLogoutRedirectURL sets the URL to return after logout, a path starts with "/" is resolved with the request host
+func WithPostLogoutRedirectURL(s string) Option {
+	return func(c *Client) {
+		c.postLogoutURL = s
+	}
+}
+
+// RevocationURI returns the revocation endpoint, empty if not configured or discovered
+func (c *Client) RevocationURI() string {
+	if len(c.revokeURI) > 0 {
+		return FixURI(c.prefix, c.revokeURI)
+	}
+	if c.meta != nil {
+		return c.meta.RevocationEndpoint
+	}
+	return ""
+}
+
+// EndSessionURI returns the end_session endpoint, empty if not configured or discovered
+func (c *Client) EndSessionURI() string {
+	if len(c.endSessionURI) > 0 {
+		return FixURI(c.prefix, c.endSessionURI)
+	}
+	if c.meta != nil {
+		return c.meta.EndSessionEndpoint
+	}
+	return ""
+}
+
+// Revoke revokes a token at the provider, hint is "access_token" or "refresh_token"
+func (c *Client) Revoke(ctx context.Context, token, hint string) error {
+	uri := c.RevocationURI()
+	if len(uri) == 0 {
+		return fmt.Errorf("revocation endpoint not configured")
+	}
+	form := url.Values{"token": {token}}
+	if len(hint) > 0 {
+		form.Set("token_type_hint", hint)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, uri, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(c.conf.ClientID), url.QueryEscape(c.conf.ClientSecret))
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("revoke: %s", resp.Status)
+	}
+	return nil
+}
+
+// revokeToken drops the cached info of the token, and revokes the refresh token and the access token,
+// failures are logged only
+func (c *Client) revokeToken(ctx context.Context, tok *oauth2.Token) {
+	if tok == nil {
+		return
+	}
+	_ = c.InvalidateInfo(ctx, tok.AccessToken)
+	if len(c.RevocationURI()) == 0 {
+		return
+	}
+	if len(tok.RefreshToken) > 0 {
+		if err := c.Revoke(ctx, tok.RefreshToken, "refresh_token"); err != nil {
+			slog.Info("revoke refresh token fail", "err", err)
+		}
+	}
+	if len(tok.AccessToken) > 0 {
+		if err := c.Revoke(ctx, tok.AccessToken, "access_token"); err != nil {
+			slog.Info("revoke access token fail", "err", err)
+		}
+	}
+}
+
+// endSessionURL returns the URL of RP-initiated logout, or the post logout URL if no end_session endpoint
+func (c *Client) endSessionURL(r *http.Request) string {
+	postLogout := c.postLogoutURL
+	if strings.HasPrefix(postLogout, "/") {
+		postLogout = getScheme(r) + "://" + r.Host + postLogout
+	}
+	es := c.EndSessionURI()
+	if len(es) == 0 {
+		return postLogout
+	}
+	u, err := url.Parse(es)
+	if err != nil {
+		slog.Info("invalid end_session endpoint", "uri", es, "err", err)
+		return postLogout
+	}
+	q := u.Query()
+	q.Set("client_id", c.conf.ClientID)
+	if len(postLogout) > 0 {
+		q.Set("post_logout_redirect_uri", postLogout)
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// LogoutClaims is the claims of an OIDC back-channel logout token
+type LogoutClaims struct {
+	Issuer    string         `json:"iss"`
+	Subject   string         `json:"sub,omitempty"`
+	Audience  Audience       `json:"aud"`
+	IssuedAt  int64          `json:"iat"`
+	Expiry    int64          `json:"exp,omitempty"`
+	JTI       string         `json:"jti,omitempty"`
+	SessionID string         `json:"sid,omitempty"`
+	Events    map[string]any `json:"events"`
+	Nonce     string         `json:"nonce,omitempty"`
+}
+
+func (lc *LogoutClaims) validate(issuer, clientID string, now time.Time) error {
+	ic := IDClaims{Issuer: lc.Issuer, Audience: lc.Audience, IssuedAt: lc.IssuedAt, Expiry: lc.Expiry}
+	if ic.Expiry == 0 {
+		ic.Expiry = now.Unix()
+	}
+	if err := ic.validate(issuer, clientID, "", now); err != nil {
+		return err
+	}
+	if _, ok := lc.Events[backChannelLogoutEvent]; !ok {
+		return fmt.Errorf("%w: backchannel-logout event not found", ErrInvalidIDToken)
+	}
+	if len(lc.Subject) == 0 && len(lc.SessionID) == 0 {
+		return fmt.Errorf("%w: sub or sid required", ErrInvalidIDToken)
+	}
+	if len(lc.Nonce) > 0 {
+		return fmt.Errorf("%w: nonce is prohibited", ErrInvalidIDToken)
+	}
+	return nil
+}
+
+// BackChannelLogout handles OIDC back-channel logout requests from the provider,
+// the client must be in OIDC mode to verify logout tokens.
+type BackChannelLogout struct {
+	// Client is the client to use, nil means the default client.
+	Client *Client
+	// OnLogout is called after the logout token is verified, for custom session stores.
+	OnLogout func(ctx context.Context, claims *LogoutClaims)
+}
+
+// BackChannelLogoutHandler returns the handler of back-channel logout
+func (c *Client) BackChannelLogoutHandler() http.Handler {
+	bl := &BackChannelLogout{Client: c}
+	return bl.Handler()
+}
+
+// Handler returns an HTTP handler that processes the back-channel logout request.
+// The sub and sid of the logout token are resolved to the UID recorded by the code callback of this process,
+// falling back to the sub. Sessions of the user signed in before now are rejected by the middleware of the client,
+// and the stored token of the user is revoked and removed.
+func (bl *BackChannelLogout) Handler() http.Handler {
+	c := bl.Client
+	if c == nil {
+		c = Default()
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		raw := r.PostFormValue("logout_token")
+		if len(raw) == 0 || c.keys == nil || c.meta == nil {
+			http.Error(w, "invalid_request", http.StatusBadRequest)
+			return
+		}
+		ctx := r.Context()
+		claims := new(LogoutClaims)
+		if err := c.keys.verifyJWT(ctx, raw, claims); err != nil {
+			slog.Info("verify logout token fail", "err", err)
+			http.Error(w, "invalid_request", http.StatusBadRequest)
+			return
+		}
+		if err := claims.validate(c.meta.Issuer, c.conf.ClientID, time.Now()); err != nil {
+			slog.Info("invalid logout token", "err", err)
+			http.Error(w, "invalid_request", http.StatusBadRequest)
+			return
+		}
+
+		uids := c.logins.resolve(claims.Subject, claims.SessionID)
+		if len(claims.Subject) > 0 {
+			c.revokeSessions(claims.Subject)
+		}
+		ts := c.TokenStore()
+		for _, uid := range uids {
+			c.revokeSessions(uid)
+			if ts != nil {
+				if tok, err := ts.Get(ctx, uid); err == nil {
+					c.revokeToken(ctx, tok)
+				}
+				_ = ts.Delete(ctx, uid)
+			}
+		}
+		uid := claims.Subject
+		if len(uids) > 0 {
+			uid = uids[0]
+		}
+		c.audit(ctx, r, AuditEvent{Type: AuditLogout, UID: uid, Reason: "back_channel"})
+		if bl.OnLogout != nil {
+			bl.OnLogout(ctx, claims)
+		}
+		slog.Info("back-channel logout", "sub", claims.Subject, "sid", claims.SessionID)
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// loginIndex maps the subject and the session ID of the ID token to the UID signed in,
+// so that back-channel logout finds the sessions and the stored token keyed by UID.
+// It lives in the memory of the process, as the revocations do.
+type loginIndex struct {
+	mu sync.Mutex
+	m  map[string]loginEntry
+}
+
+type loginEntry struct {
+	uid string
+	at  int64
+}
+
+// record keeps the UID for the subject and the session ID, empty ones are ignored
+func (li *loginIndex) record(sub, sid, uid string) {
+	if len(uid) == 0 || (len(sub) == 0 && len(sid) == 0) {
+		return
+	}
+	now := time.Now().Unix()
+	li.mu.Lock()
+	defer li.mu.Unlock()
+	if li.m == nil {
+		li.m = make(map[string]loginEntry)
+	}
+	for k, e := range li.m {
+		if now-e.at > auth.DefaultLifetime {
+			delete(li.m, k)
+		}
+	}
+	if len(sub) > 0 {
+		li.m["sub:"+sub] = loginEntry{uid: uid, at: now}
+	}
+	if len(sid) > 0 {
+		li.m["sid:"+sid] = loginEntry{uid: uid, at: now}
+	}
+}
+
+// resolve returns the UIDs signed in with the session ID or the subject, or the subject if none recorded
+func (li *loginIndex) resolve(sub, sid string) (uids []string) {
+	li.mu.Lock()
+	defer li.mu.Unlock()
+	add := func(key string) {
+		if e, ok := li.m[key]; ok && !slices.Contains(uids, e.uid) {
+			uids = append(uids, e.uid)
+		}
+	}
+	if len(sid) > 0 {
+		add("sid:" + sid)
+	}
+	if len(sub) > 0 {
+		add("sub:" + sub)
+	}
+	if len(uids) == 0 && len(sub) > 0 {
+		uids = append(uids, sub)
+	}
+	return
+}
+
+// sessionRevocations keeps the subjects logged out by the provider with the time,
+// sessions refreshed before the time are rejected.
+type sessionRevocations struct {
+	mu sync.Mutex
+	m  map[string]int64
+}
+
+func (c *Client) revokeSessions(sub string) {
+	now := time.Now().Unix()
+	c.revocations.mu.Lock()
+	defer c.revocations.mu.Unlock()
+	if c.revocations.m == nil {
+		c.revocations.m = make(map[string]int64)
+	}
+	for k, at := range c.revocations.m {
+		if now-at > auth.DefaultLifetime {
+			delete(c.revocations.m, k)
+		}
+	}
+	c.revocations.m[sub] = now
+	c.bearers.forgetUser(sub)
+}
+
+// isRevoked checks whether the session of the user was logged out by the provider
+func (c *Client) isRevoked(user *auth.User) bool {
+	c.revocations.mu.Lock()
+	defer c.revocations.mu.Unlock()
+	for _, key := range []string{user.OID, user.UID} {
+		if at, ok := c.revocations.m[key]; ok && len(key) > 0 && user.LastHit <= at {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestClient_LogoutHandler(t *testing.T) {
+	var mu sync.Mutex
+	var revoked []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		mu.Lock()
+		revoked = append(revoked, r.PostForm.Get("token_type_hint")+":"+r.PostForm.Get("token"))
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	store := NewMemoryTokenStore()
+	c := New(WithPrefix(srv.URL), WithClientID("cid", "secret"), WithTokenStore(store),
+		WithAuthorizer(NewAuth(WithCookie("_logout"))),
+		WithRevocationURI("revoke"), WithEndSessionURI("logout"), WithPostLogoutRedirectURL("/bye"))
+	require.NoError(t, store.Put(context.Background(), "alice", &oauth2.Token{AccessToken: "a1", RefreshToken: "r1"}))
+
+	user := &User{UID: "alice"}
+	user.Refresh()
+	rec := httptest.NewRecorder()
+	require.NoError(t, c.Signin(user, rec))
+
+	r := httptest.NewRequest(http.MethodGet, "http://app.example.com/auth/logout", nil)
+	for _, ck := range rec.Result().Cookies() {
+		r.AddCookie(ck)
+	}
+	rec = httptest.NewRecorder()
+	c.LogoutHandler(rec, r)
+
+	assert.Equal(t, []string{"refresh_token:r1", "access_token:a1"}, revoked)
+	_, err := store.Get(context.Background(), "alice")
+	assert.ErrorIs(t, err, ErrTokenNotFound)
+
+	assert.Equal(t, http.StatusFound, rec.Code)
+	loc, err := url.Parse(rec.Header().Get("Location"))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(loc.String(), srv.URL+"/logout?"))
+	assert.Equal(t, "http://app.example.com/bye", loc.Query().Get("post_logout_redirect_uri"))
+	assert.Equal(t, "cid", loc.Query().Get("client_id"))
+}
+
+func TestBackChannelLogout(t *testing.T) {
+	ts := newTestSigner(t)
+	srv := newTestProvider(t, ts)
+	c, err := NewWithDiscovery(context.Background(), WithPrefix(srv.URL), WithClientID("cid", "secret"),
+		WithAuthorizer(NewAuth(WithCookie("_bcl"))))
+	require.NoError(t, err)
+
+	user := &User{OID: "u1", UID: "alice"}
+	user.LastHit = time.Now().Add(-time.Second).Unix()
+	rec := httptest.NewRecorder()
+	require.NoError(t, c.Signin(user, rec))
+	cookies := rec.Result().Cookies()
+
+	protected := c.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	get := func() int {
+		r := httptest.NewRequest(http.MethodGet, "/admin/", nil)
+		for _, ck := range cookies {
+			r.AddCookie(ck)
+		}
+		rec := httptest.NewRecorder()
+		protected.ServeHTTP(rec, r)
+		return rec.Code
+	}
+	assert.Equal(t, http.StatusOK, get())
+
+	now := time.Now().Unix()
+	post := func(claims map[string]any) int {
+		form := url.Values{"logout_token": {ts.sign(t, "RS256", "r1", claims)}}
+		r := httptest.NewRequest(http.MethodPost, "/auth/backchannel", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		c.BackChannelLogoutHandler().ServeHTTP(rec, r)
+		return rec.Code
+	}
+	events := map[string]any{backChannelLogoutEvent: map[string]any{}}
+	assert.Equal(t, http.StatusBadRequest, post(map[string]any{"iss": srv.URL, "aud": "cid", "iat": now, "sub": "u1"}))
+	assert.Equal(t, http.StatusBadRequest, post(map[string]any{"iss": srv.URL, "aud": "cid", "iat": now, "sub": "u1",
+		"events": events, "nonce": "n"}))
+	assert.Equal(t, http.StatusOK, get())
+
+	assert.Equal(t, http.StatusOK, post(map[string]any{"iss": srv.URL, "aud": "cid", "iat": now, "sub": "u1",
+		"events": events}))
+	assert.Equal(t, http.StatusUnauthorized, get())
+}
+
+func TestBackChannelLogout_SessionID(t *testing.T) {
+	ts := newTestSigner(t)
+	srv := newTestProvider(t, ts)
+	store := NewMemoryTokenStore()
+	c, err := NewWithDiscovery(context.Background(), WithPrefix(srv.URL), WithClientID("cid", "secret"),
+		WithAuthorizer(NewAuth(WithCookie("_bcl"))), WithTokenStore(store))
+	require.NoError(t, err)
+
+	now := time.Now().Unix()
+	events := map[string]any{backChannelLogoutEvent: map[string]any{}}
+	tests := []struct {
+		name   string
+		claims map[string]any
+		uid    string
+	}{
+		{"仅 sid", map[string]any{"sid": "s1"}, "alice"},
+		{"sub 映射到 UID", map[string]any{"sub": "u2"}, "bob"},
+		{"未记录的 sub", map[string]any{"sub": "carol"}, "carol"},
+	}
+	c.logins.record("u1", "s1", "alice")
+	c.logins.record("u2", "", "bob")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			require.NoError(t, store.Put(ctx, tt.uid, &oauth2.Token{AccessToken: "at-" + tt.uid}))
+			user := &User{UID: tt.uid}
+			user.LastHit = now - 1
+
+			claims := map[string]any{"iss": srv.URL, "aud": "cid", "iat": now, "events": events}
+			for k, v := range tt.claims {
+				claims[k] = v
+			}
+			form := url.Values{"logout_token": {ts.sign(t, "RS256", "r1", claims)}}
+			r := httptest.NewRequest(http.MethodPost, "/auth/backchannel", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rec := httptest.NewRecorder()
+			c.BackChannelLogoutHandler().ServeHTTP(rec, r)
+			assert.Equal(t, http.StatusOK, rec.Code)
+
+			_, err := store.Get(ctx, tt.uid)
+			assert.Error(t, err, "token removed")
+			assert.True(t, c.isRevoked(user))
+		})
+	}
+}
@@ -1,30 +1,190 @@
 package client
 
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
 type Meta map[string]any
 
-// Get ...
+// Get returns the value of the key, or of the nested path like "org.dept.name"
 func (m Meta) Get(key string) (v any, ok bool) {
-	v, ok = m[key]
-	return
+	if v, ok = m[key]; ok || !strings.Contains(key, ".") {
+		return
+	}
+	var cur any = map[string]any(m)
+	for _, k := range strings.Split(key, ".") {
+		switch z := cur.(type) {
+		case map[string]any:
+			cur, ok = z[k]
+		case Meta:
+			cur, ok = z[k]
+		default:
+			ok = false
+		}
+		if !ok {
+			return nil, false
+		}
+	}
+	return cur, true
 }
 
 func (m Meta) GetInt(key string) int {
-	if v, ok := m[key]; ok {
-		switch z := v.(type) {
-		case float64:
-			return int(z)
-		case int:
-			return z
+	return int(m.GetInt64(key))
+}
+
+// GetInt64 returns the value as int64, numbers in string or json.Number are parsed, 0 if not a number
+func (m Meta) GetInt64(key string) int64 {
+	v, _ := m.Get(key)
+	switch z := v.(type) {
+	case int:
+		return int64(z)
+	case int64:
+		return z
+	case int32:
+		return int64(z)
+	case float64:
+		return int64(z)
+	case float32:
+		return int64(z)
+	case json.Number:
+		if n, err := z.Int64(); err == nil {
+			return n
+		}
+		f, _ := z.Float64()
+		return int64(f)
+	case string:
+		if n, err := strconv.ParseInt(strings.TrimSpace(z), 10, 64); err == nil {
+			return n
 		}
+		f, _ := strconv.ParseFloat(strings.TrimSpace(z), 64)
+		return int64(f)
 	}
 	return 0
 }
 
+// GetFloat returns the value as float64, numbers in string or json.Number are parsed, 0 if not a number
+func (m Meta) GetFloat(key string) float64 {
+	v, _ := m.Get(key)
+	switch z := v.(type) {
+	case float64:
+		return z
+	case float32:
+		return float64(z)
+	case int:
+		return float64(z)
+	case int64:
+		return float64(z)
+	case int32:
+		return float64(z)
+	case json.Number:
+		f, _ := z.Float64()
+		return f
+	case string:
+		f, _ := strconv.ParseFloat(strings.TrimSpace(z), 64)
+		return f
+	}
+	return 0
+}
+
+// GetBool returns the value as bool, strings like "true" or "1" are parsed, and a non-zero number is true
+func (m Meta) GetBool(key string) bool {
+	v, _ := m.Get(key)
+	switch z := v.(type) {
+	case bool:
+		return z
+	case string:
+		b, _ := strconv.ParseBool(strings.TrimSpace(z))
+		return b
+	case json.Number, float64, float32, int, int64, int32:
+		return m.GetFloat(key) != 0
+	}
+	return false
+}
+
 func (m Meta) GetStr(key string) string {
-	if v, ok := m[key]; ok {
-		if s, ok := v.(string); ok {
-			return s
-		}
+	v, _ := m.Get(key)
+	switch z := v.(type) {
+	case string:
+		return z
+	case json.Number:
+		return z.String()
 	}
 	return ""
 }
+
+// GetStrings returns the value as []string, a single string becomes one element, other elements are formatted
+func (m Meta) GetStrings(key string) []string {
+	v, _ := m.Get(key)
+	switch z := v.(type) {
+	case []string:
+		return z
+	case string:
+		return []string{z}
+	case []any:
+		ss := make([]string, 0, len(z))
+		for _, e := range z {
+			if s, ok := e.(string); ok {
+				ss = append(ss, s)
+			} else if e != nil {
+				ss = append(ss, fmt.Sprint(e))
+			}
+		}
+		return ss
+	}
+	return nil
+}
+
+// GetTime returns the value as time.Time, strings are parsed with the layouts, default are RFC 3339,
+// time.DateTime and time.DateOnly. Numbers are unix seconds, or milliseconds if too large for seconds.
+// The zero time is returned if not a time.
+func (m Meta) GetTime(key string, layouts ...string) time.Time {
+	v, ok := m.Get(key)
+	if !ok {
+		return time.Time{}
+	}
+	switch z := v.(type) {
+	case time.Time:
+		return z
+	case string:
+		if len(layouts) == 0 {
+			layouts = []string{time.RFC3339Nano, time.DateTime, time.DateOnly}
+		}
+		for _, layout := range layouts {
+			if t, err := time.Parse(layout, strings.TrimSpace(z)); err == nil {
+				return t
+			}
+		}
+		return time.Time{}
+	case json.Number, float64, float32, int, int64, int32:
+		f := m.GetFloat(key)
+		if math.Abs(f) >= 1e12 {
+			return time.UnixMilli(int64(f))
+		}
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(frac*1e9))
+	}
+	return time.Time{}
+}
+
+// Decode maps the meta onto a struct with json tags, a type mismatch is reported with the field
+func (m Meta) Decode(into any) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("meta: %w", err)
+	}
+	err = json.Unmarshal(b, into)
+	var te *json.UnmarshalTypeError
+	if errors.As(err, &te) {
+		return fmt.Errorf("meta: field %q expects %s, got %s: %w", te.Field, te.Type, te.Value, err)
+	}
+	if err != nil {
+		return fmt.Errorf("meta: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testMeta(t *testing.T) Meta {
+	var m Meta
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"active": true, "admin": "1", "level": "42", "score": 3.5, "count": 7,
+		"tags": ["a", "b", 3], "alias": "x", "joined": "2024-05-01T08:00:00Z", "born": "1990-01-02",
+		"seen": 1700000000, "seenMs": 1700000000123,
+		"org": {"dept": {"name": "dev", "size": "12"}}, "a.b": "flat"
+	}`), &m))
+	m["big"] = json.Number("9007199254740993")
+	return m
+}
+
+func TestMeta_Accessors(t *testing.T) {
+	m := testMeta(t)
+	tests := []struct {
+		name string
+		got  any
+		want any
+	}{
+		{"布尔值", m.GetBool("active"), true},
+		{"字符串布尔", m.GetBool("admin"), true},
+		{"缺失布尔", m.GetBool("none"), false},
+		{"字符串整数", m.GetInt64("level"), int64(42)},
+		{"json.Number 整数", m.GetInt64("big"), int64(9007199254740993)},
+		{"浮点转整数", m.GetInt("score"), 3},
+		{"整数转浮点", m.GetFloat("count"), 7.0},
+		{"字符串浮点", m.GetFloat("level"), 42.0},
+		{"字符串数组", m.GetStrings("tags"), []string{"a", "b", "3"}},
+		{"单个字符串", m.GetStrings("alias"), []string{"x"}},
+		{"嵌套路径", m.GetStr("org.dept.name"), "dev"},
+		{"嵌套数字", m.GetInt("org.dept.size"), 12},
+		{"带点的键优先", m.GetStr("a.b"), "flat"},
+		{"缺失路径", m.GetStr("org.team.name"), ""},
+		{"RFC 3339", m.GetTime("joined"), time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)},
+		{"日期", m.GetTime("born"), time.Date(1990, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"自定义格式", m.GetTime("born", "2006-01-02"), time.Date(1990, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"格式不符", m.GetTime("born", time.Kitchen), time.Time{}},
+		{"Unix 秒", m.GetTime("seen").Unix(), int64(1700000000)},
+		{"Unix 毫秒", m.GetTime("seenMs").UnixMilli(), int64(1700000000123)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.got)
+		})
+	}
+}
+
+func TestMeta_Decode(t *testing.T) {
+	m := testMeta(t)
+	var out struct {
+		Active bool      `json:"active"`
+		Tags   []any     `json:"tags"`
+		Joined time.Time `json:"joined"`
+		Org    struct {
+			Dept struct {
+				Name string `json:"name"`
+			} `json:"dept"`
+		} `json:"org"`
+	}
+	require.NoError(t, m.Decode(&out))
+	assert.True(t, out.Active)
+	assert.Equal(t, "dev", out.Org.Dept.Name)
+	assert.Equal(t, 2024, out.Joined.Year())
+
+	var bad struct {
+		Level int `json:"level"`
+	}
+	err := m.Decode(&bad)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `field "level" expects int, got string`)
+	var te *json.UnmarshalTypeError
+	assert.True(t, errors.As(err, &te))
+}
@@ -2,26 +2,22 @@ package client
 
 import (
 	"context"
-	"crypto/tls"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"log/slog"
 	"net/http"
 	"strings"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/oauth2"
 )
 
 var (
-	tlscfg = &tls.Config{
-		InsecureSkipVerify: true,
-	}
-	httpClient = &http.Client{
-		Timeout:   9 * time.Second,
-		Transport: &http.Transport{TLSClientConfig: tlscfg},
-	}
+	httpClient = newHTTPClient(nil)
 
 	ErrNoToken = errors.New("oauth2 token not found")
 	ErrNoRole  = errors.New("the user not in special roles")
@@ -34,6 +30,9 @@ type ctxKey int
 
 const (
 	TokenKey ctxKey = iota
+	IDClaimsKey
+	StateDataKey
+	InfoTokenKey
 )
 
 func SetLoginPath(path string) {
@@ -47,16 +46,68 @@ func SetAdminPath(path string) {
 
 // AuthMiddleware ...
 func AuthMiddleware(redirect bool) func(next http.Handler) http.Handler {
+	return Default().AuthMiddleware(redirect)
+}
+
+// AuthMiddleware ...
+func (c *Client) AuthMiddleware(redirect bool) func(next http.Handler) http.Handler {
 	if redirect {
-		WithURI(LoginPath)
-		return authoriz.MiddlewareWordy(true)
+		return c.MiddlewareWordy(true)
+	}
+	return c.Middleware()
+}
+
+// Middleware returns an HTTP middleware which rejects sessions logged out by the provider.
+func (c *Client) Middleware() func(next http.Handler) http.Handler {
+	return c.MiddlewareWordy(false)
+}
+
+// MiddlewareWordy returns an HTTP middleware, which redirects an unauthenticated user
+// to the login path with the requested URL as return_to when redir is true.
+// Only GET and HEAD navigations are redirected, AJAX and other requests get 401 rendered by the ErrorHandler.
+func (c *Client) MiddlewareWordy(redir bool) func(next http.Handler) http.Handler {
+	mw := c.Authorizer.Middleware()
+	return func(next http.Handler) http.Handler {
+		inner := mw(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, err := c.UserFromRequest(r)
+			if err == nil && c.isRevoked(user) {
+				c.Signout(w)
+				err = ErrSessionRevoked
+			}
+			if err == nil && c.revalidate && user.NeedRefresh() {
+				if err = c.revalidateUser(r.Context(), user); err != nil {
+					slog.Info("revalidation rejected, sign out", "uid", user.UID, "err", err)
+					c.Signout(w)
+				} else {
+					user.Refresh()
+					_ = c.Signin(user, w)
+					next.ServeHTTP(w, r.WithContext(ContextWithUser(r.Context(), user)))
+					return
+				}
+			}
+			if err != nil {
+				if redir && isNavigation(r) {
+					http.Redirect(w, r, c.LoginURL(r.URL.RequestURI()), http.StatusFound)
+				} else {
+					c.handleError(w, r, &OAuthError{Code: "unauthorized", Description: "sign in required",
+						Status: http.StatusUnauthorized, Err: err})
+				}
+				return
+			}
+			inner.ServeHTTP(w, r)
+		})
 	}
-	return authoriz.Middleware()
 }
 
 // AuthCodeCallback Handler for Check auth with role[s] when auth-code callback
 func AuthCodeCallback(roles ...string) http.Handler {
-	cc := &CodeCallback{InRoles: roles}
+	return Default().AuthCodeCallback(roles...)
+}
+
+// AuthCodeCallback Handler for Check auth with role[s] when auth-code callback
+func (c *Client) AuthCodeCallback(roles ...string) http.Handler {
+	cc := &CodeCallback{Client: c, InRoles: roles}
 	return cc.Handler()
 }
 
@@ -68,6 +119,8 @@ type UserFunc = func(ctx context.Context, w http.ResponseWriter, user *O2User)
 
 // CodeCallback handles OAuth2 authorization code callback with role checking.
 type CodeCallback struct {
+	// Client is the client to use, nil means the default client.
+	Client *Client
 	// InRoles specifies the roles required for authorization.
 	InRoles []string
 	// OnTokenGot is called after receiving the infoToken from the provider.
@@ -78,11 +131,15 @@ type CodeCallback struct {
 
 // Handler returns an HTTP handler that processes the callback request.
 func (cc *CodeCallback) Handler() http.Handler {
+	c := cc.Client
+	if c == nil {
+		c = Default()
+	}
 	hf := func(w http.ResponseWriter, r *http.Request) {
-		it, err := AuthRequestWithRole(r, cc.InRoles...)
+		it, err := c.AuthRequestWithRole(r, cc.InRoles...)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusUnauthorized)
 			slog.Info("auth fail", "roles", cc.InRoles, "err", err)
+			c.failLogin(w, r, err)
 			return
 		}
 
@@ -92,13 +149,35 @@ func (cc *CodeCallback) Handler() http.Handler {
 
 		ue, ok := it.GetUser()
 		if !ok {
-			http.Error(w, "user not found in api/info result", http.StatusUnauthorized)
 			slog.Info("auth fail, user not found", "infoToken", it)
+			c.failLogin(w, r, NewOAuthError("user_not_found", "user not found in api/info result", http.StatusUnauthorized))
+			return
+		}
+		var attrs []attribute.KeyValue
+		if c.telemetryUID {
+			attrs = append(attrs, attribute.String("uid", ue.UID))
+		}
+		_, span := c.tel().start(r.Context(), "staffio.Signin", attrs...)
+		err = c.Signin(ue, w)
+		spanEnd(span, err)
+		if err != nil {
+			slog.Info("signin fail", "err", err)
+			c.failLogin(w, r, err)
 			return
 		}
-		_ = authoriz.Signin(ue, w)
+		c.tel().successes.Add(r.Context(), 1)
+		c.audit(r.Context(), r, AuditEvent{Type: AuditLoginSucceeded, UID: ue.UID})
 
-		defaultStateStore.Wipe(w, r.FormValue("state"))
+		if claims := IDClaimsFromContext(r.Context()); claims != nil {
+			c.logins.record(claims.Subject, claims.SessionID, ue.UID)
+		}
+		if ts := c.TokenStore(); ts != nil {
+			if err = ts.Put(r.Context(), ue.UID, TokenFromContext(r.Context())); err != nil {
+				slog.Info("put token fail", "uid", ue.UID, "err", err)
+			}
+		}
+
+		c.stateWipe(w, r, r.FormValue("state"))
 
 		if cc.OnSignedIn != nil {
 			cc.OnSignedIn(r.Context(), w, ue)
@@ -115,43 +194,92 @@ func (cc *CodeCallback) Handler() http.Handler {
 			return
 		}
 		// redirect
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		w.Header().Set("Refresh", fmt.Sprintf("2; %s", AdminPath))
-		w.WriteHeader(http.StatusAccepted)
-		out := fmt.Sprintf(
-			"Welcome back <b>%s</b>. Please waiting, or click <a href=%q>here</a> to go back",
-			ue.GetName(), AdminPath)
-		_, _ = w.Write([]byte(out))
+		http.Redirect(w, r, c.returnTo(r), http.StatusFound)
 	}
-	return AuthCodeCallbackWrap(http.HandlerFunc(hf))
+	return c.AuthCodeCallbackWrap(http.HandlerFunc(hf))
 }
 
 // AuthCodeCallbackWrap is a middleware that injects a InfoToken with roles into the context of callback request
 func AuthCodeCallbackWrap(next http.Handler) http.Handler {
+	return Default().AuthCodeCallbackWrap(next)
+}
+
+// AuthCodeCallbackWrap is a middleware that injects a InfoToken with roles into the context of callback request
+func (c *Client) AuthCodeCallbackWrap(next http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
+		tel := c.tel()
+		ctx, span := tel.start(r.Context(), "staffio.Callback")
+		defer span.End()
+		r = r.WithContext(ctx)
+		tel.attempts.Add(ctx, 1)
+
 		// verify state value.
 		state := r.FormValue("state")
-		if !defaultStateStore.Verify(r, state) {
-			slog.Info("invalid", "stateF", state, "stateS", StateGet(r), "uri", r.RequestURI)
-			http.Error(w, "invalid state: "+state, http.StatusBadRequest)
+		_, vspan := tel.start(ctx, "staffio.VerifyState")
+		sd, ok := c.stateVerify(r, state)
+		vspan.End()
+		if !ok {
+			slog.Info("invalid", "stateF", state, "uri", r.RequestURI)
+			c.failLogin(w, r, NewOAuthError(ErrInvalidState.Code, "the state is invalid or expired", http.StatusBadRequest))
 			return
 		}
-		ctx := r.Context()
-		ctxEx := context.WithValue(ctx, oauth2.HTTPClient, httpClient)
+		// the error returned by the provider
+		if oe := callbackError(r); oe != nil {
+			c.stateWipe(w, r, state)
+			c.failLogin(w, r, oe)
+			return
+		}
+		ctxEx := context.WithValue(ctx, oauth2.HTTPClient, c.httpClient)
 
-		tok, err := confSgt().Exchange(ctxEx, r.FormValue("code"), getAuthCodeOption(r))
+		opts := []oauth2.AuthCodeOption{c.getAuthCodeOption(r)}
+		if len(sd.Verifier) > 0 {
+			opts = append(opts, oauth2.VerifierOption(sd.Verifier))
+		}
+		ctxEx, espan := tel.start(ctxEx, "staffio.Exchange")
+		start := time.Now()
+		tok, err := c.conf.Exchange(ctxEx, r.FormValue("code"), opts...)
+		tel.exchanged(ctx, start, err)
+		spanEnd(espan, err)
 		if err != nil {
-			slog.Info("oauth2 exchange fail", "err", err, "euri", confSgt().Endpoint.TokenURL)
-			http.Error(w, "oauth2 exchange fail: "+err.Error(), http.StatusBadRequest)
+			slog.Info("oauth2 exchange fail", "err", err, "euri", c.conf.Endpoint.TokenURL)
+			c.failLogin(w, r, AsOAuthError(err))
 			return
 		}
 
+		if c.oidc {
+			claims, err := c.verifyTokenID(ctx, tok, sd)
+			if err != nil {
+				slog.Info("verify id_token fail", "err", err)
+				c.failLogin(w, r, &OAuthError{Code: "invalid_id_token", Status: http.StatusBadRequest, Err: err})
+				return
+			}
+			ctx = context.WithValue(ctx, IDClaimsKey, claims)
+		}
+
+		ctx = context.WithValue(ctx, StateDataKey, sd)
 		ctx = context.WithValue(ctx, TokenKey, tok)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	}
 	return http.HandlerFunc(fn)
 }
 
+// failLogin records the failure of the callback, and renders it with the error handler
+func (c *Client) failLogin(w http.ResponseWriter, r *http.Request, err error) {
+	ctx := r.Context()
+	reason := AsOAuthError(err).Code
+	c.tel().failures.Add(ctx, 1, metric.WithAttributes(attribute.String("reason", reason)))
+	span := trace.SpanFromContext(ctx)
+	span.RecordError(err)
+	span.SetStatus(codes.Error, reason)
+
+	ev := AuditEvent{Type: AuditLoginFailed, Reason: reason}
+	if reason == ErrInvalidState.Code {
+		ev.Type = AuditStateMismatch
+	}
+	c.audit(ctx, r, ev)
+	c.handleError(w, r, err)
+}
+
 // UidFromToken extract uid from oauth2.Token
 func UidFromToken(tok *oauth2.Token) string {
 	if uid, ok := tok.Extra("uid").(string); ok {
@@ -175,26 +303,58 @@ func TokenFromContext(ctx context.Context) *oauth2.Token {
 
 // AuthRequestWithRole called in AuthCallback
 func AuthRequestWithRole(r *http.Request, role ...string) (it *InfoToken, err error) {
+	return Default().AuthRequestWithRole(r, role...)
+}
+
+// AuthRequestWithRole called in AuthCallback
+func (c *Client) AuthRequestWithRole(r *http.Request, role ...string) (it *InfoToken, err error) {
 	ctx := r.Context()
 	tok := TokenFromContext(ctx)
 	if tok == nil {
 		err = ErrNoToken
 		return
 	}
-	it, err = RequestInfoToken(ctx, tok, role...)
-	if err != nil {
-		return
+	tel := c.tel()
+	if claims := IDClaimsFromContext(ctx); claims != nil {
+		it = claims.withInfoToken(c.newInfoToken(tok))
+		c.expandRoles(it)
+	} else {
+		ictx, span := tel.start(ctx, "staffio.RequestInfo")
+		it, err = c.RequestInfoToken(ictx, tok, c.requestRoles(role)...)
+		spanEnd(span, err)
+		if err != nil {
+			return
+		}
 	}
+	_, span := tel.start(ctx, "staffio.CheckRole", attribute.StringSlice("roles", role))
+	rs := it.RoleSet()
 	for _, rn := range role {
-		if !it.Roles.Has(rn) {
-			err = ErrNoRole
+		if !rs.Has(rn) {
+			err = c.denyRole(r, it, rn)
 			break
 		}
 	}
+	if err == nil && c.requiredRoles != nil && !c.requiredRoles.Eval(rs) {
+		err = c.denyRole(r, it, c.requiredRoles.String())
+	}
+	spanEnd(span, err)
 
 	return
 }
 
+// denyRole records the denial of the role, and returns the missing_role error
+func (c *Client) denyRole(r *http.Request, it *InfoToken, role string) error {
+	c.tel().denied(r.Context(), role)
+	c.audit(r.Context(), r, AuditEvent{Type: AuditRoleDenied, UID: it.uid(), Role: role})
+	return &OAuthError{Code: ErrMissingRole.Code, Description: "role " + role + " required",
+		Status: http.StatusForbidden, Err: ErrNoRole}
+}
+
+// isNavigation checks the request is a GET or HEAD of a page, which can be redirected to login
+func isNavigation(r *http.Request) bool {
+	return (r.Method == http.MethodGet || r.Method == http.MethodHead) && !IsAjax(r)
+}
+
 // IsAjax Check if is AJAX Request for json data
 func IsAjax(r *http.Request) bool {
 	if acceptHeaders, ok := r.Header["Accept"]; ok {
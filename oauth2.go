@@ -5,53 +5,20 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"log/slog"
 	"net/http"
 	"os"
 	"strings"
-	"sync"
 
 	"golang.org/x/oauth2"
 )
 
 var (
-	conf2     *oauth2.Config
-	cOnce     sync.Once
-	prefix    string
-	infoURI   string
 	envPrefix = "OAUTH"
 )
 
+// GetPrefix returns the base URL of the provider of the default client
 func GetPrefix() string {
-	return prefix
-}
-
-func init() {
-	prefix = envOrP("PREFIX", "https://staffio.work")
-	infoURI = FixURI(prefix, envOrP("URI_INFO", "info/me"))
-}
-
-func confSgt() *oauth2.Config {
-	cOnce.Do(func() {
-		conf2 = &oauth2.Config{Endpoint: oauth2.Endpoint{
-			AuthURL:  FixURI(prefix, envOrP("URI_AUTHORIZE", "authorize")),
-			TokenURL: FixURI(prefix, envOrP("URI_TOKEN", "token")),
-		}}
-		clientID := envOrP("CLIENT_ID", "")
-		clientSecret := envOrP("CLIENT_SECRET", "")
-		if clientID == "" || clientSecret == "" {
-			slog.Warn(envPrefix + "_CLIENT_ID or " + envPrefix + "_CLIENT_SECRET not found in environment")
-		} else {
-			SetupClient(conf2, clientID, clientSecret)
-		}
-
-		SetupRedirectURL(conf2, envOrP("REDIRECT_URL", "/auth/callback"))
-
-		if scopes := strings.Split(envOrP("SCOPES", ""), ","); len(scopes) > 0 {
-			SetupScopes(conf2, scopes)
-		}
-	})
-	return conf2
+	return Default().Prefix()
 }
 
 func envName(k string) string {
@@ -93,41 +60,69 @@ func SetupScopes(conf *oauth2.Config, scopes []string) {
 
 // LoginStart generate state into cookie and return redirectURI
 func LoginStart(w http.ResponseWriter, r *http.Request) string {
-	state := randToken()
-	_ = defaultStateStore.Save(w, state)
+	return Default().LoginStart(w, r)
+}
 
-	if strings.HasPrefix(confSgt().RedirectURL, "/") {
-		return confSgt().AuthCodeURL(state, getAuthCodeOption(r))
+// LoginStart generate state into cookie and return redirectURI,
+// the return_to parameter of request is kept with the state if it is allowed.
+func (c *Client) LoginStart(w http.ResponseWriter, r *http.Request) string {
+	_, span := c.tel().start(r.Context(), "staffio.LoginStart")
+	defer span.End()
+	sd := c.stateStart(w, r)
+	c.audit(r.Context(), r, AuditEvent{Type: AuditLoginStarted})
+
+	var opts []oauth2.AuthCodeOption
+	if len(sd.Verifier) > 0 {
+		opts = append(opts, oauth2.S256ChallengeOption(sd.Verifier))
+	}
+	if len(sd.Nonce) > 0 {
+		opts = append(opts, oauth2.SetAuthURLParam("nonce", sd.Nonce))
 	}
-	return confSgt().AuthCodeURL(state)
+	if strings.HasPrefix(c.conf.RedirectURL, "/") {
+		opts = append(opts, c.getAuthCodeOption(r))
+	}
+	return c.conf.AuthCodeURL(sd.State, opts...)
 }
 
 type AuthFormData struct {
-	ResponseType string `json:"response_type"`
-	ClientID     string `json:"client_id"`
-	RedirectURI  string `json:"redirect_uri"`
-	Scope        string `json:"scope"`
-	State        string `json:"state"`
+	ResponseType        string `json:"response_type"`
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	CodeChallenge       string `json:"code_challenge,omitempty"`
+	CodeChallengeMethod string `json:"code_challenge_method,omitempty"`
+	Nonce               string `json:"nonce,omitempty"`
 }
 
 // LoginHandler handles login requests. For Ajax requests, returns authorization form data;
 // otherwise redirects to the authorization page or displays a login page.
 func LoginHandler(w http.ResponseWriter, r *http.Request) {
+	Default().LoginHandler(w, r)
+}
+
+// LoginHandler handles login requests. For Ajax requests, returns authorization form data;
+// otherwise redirects to the authorization page or displays a login page.
+func (c *Client) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	if IsAjax(r) {
-		state := randToken()
-		_ = defaultStateStore.Save(w, state)
-		cc := confSgt()
+		sd := c.stateStart(w, r)
+		cc := c.conf
 		data := AuthFormData{
 			ResponseType: "code",
 			ClientID:     cc.ClientID,
-			RedirectURI:  getRedirectURI(r),
+			RedirectURI:  c.getRedirectURI(r),
 			Scope:        strings.Join(cc.Scopes, " "),
-			State:        state,
+			State:        sd.State,
+			Nonce:        sd.Nonce,
+		}
+		if len(sd.Verifier) > 0 {
+			data.CodeChallenge = oauth2.S256ChallengeFromVerifier(sd.Verifier)
+			data.CodeChallengeMethod = "S256"
 		}
 		json.NewEncoder(w).Encode(map[string]any{"data": data}) //nolint
 		return
 	}
-	location := LoginStart(w, r)
+	location := c.LoginStart(w, r)
 	w.Header().Set("refresh", fmt.Sprintf("1; %s", location))
 	title := envOr("AUTH_TITLE", "Staffio")
 	_, _ = w.Write([]byte("<html><title>" + title + "</title> <body style='padding: 2em;'> <p>Waiting...</p> <a href='" +
@@ -136,7 +131,27 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 
 // LogoutHandler ...
 func LogoutHandler(w http.ResponseWriter, r *http.Request) {
-	Signout(w)
+	Default().LogoutHandler(w, r)
+}
+
+// LogoutHandler signs out the local user, revokes the stored tokens of the user at the provider,
+// and redirects to the end_session endpoint of the provider if it is known.
+func (c *Client) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if user, _ := c.UserFromRequest(r); user != nil {
+		ctx := r.Context()
+		if ts := c.TokenStore(); ts != nil {
+			if tok, err := ts.Get(ctx, user.UID); err == nil {
+				c.revokeToken(ctx, tok)
+			}
+			_ = ts.Delete(ctx, user.UID)
+		}
+		c.audit(ctx, r, AuditEvent{Type: AuditLogout, UID: user.UID})
+	}
+	c.Signout(w)
+
+	if location := c.endSessionURL(r); len(location) > 0 {
+		http.Redirect(w, r, location, http.StatusFound)
+	}
 }
 
 func envOr(key, dft string) string {
@@ -157,15 +172,15 @@ func envOrP(key, dft string) string {
 	return envOr(key, dft)
 }
 
-func getAuthCodeOption(r *http.Request) oauth2.AuthCodeOption {
-	return oauth2.SetAuthURLParam("redirect_uri", getRedirectURI(r))
+func (c *Client) getAuthCodeOption(r *http.Request) oauth2.AuthCodeOption {
+	return oauth2.SetAuthURLParam("redirect_uri", c.getRedirectURI(r))
 }
 
-func getRedirectURI(r *http.Request) string {
-	if strings.HasPrefix(confSgt().RedirectURL, "/") {
-		return getScheme(r) + "://" + r.Host + confSgt().RedirectURL
+func (c *Client) getRedirectURI(r *http.Request) string {
+	if strings.HasPrefix(c.conf.RedirectURL, "/") {
+		return getScheme(r) + "://" + r.Host + c.conf.RedirectURL
 	}
-	return confSgt().RedirectURL
+	return c.conf.RedirectURL
 }
 
 func getScheme(r *http.Request) string {
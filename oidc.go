@@ -0,0 +1,167 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const wellKnownPath = "/.well-known/openid-configuration"
+
+// ProviderMetadata is the OpenID Provider Metadata from discovery
+type ProviderMetadata struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	UserinfoEndpoint      string   `json:"userinfo_endpoint,omitempty"`
+	JwksURI               string   `json:"jwks_uri"`
+	EndSessionEndpoint    string   `json:"end_session_endpoint,omitempty"`
+	RevocationEndpoint    string   `json:"revocation_endpoint,omitempty"`
+	IntrospectionEndpoint string   `json:"introspection_endpoint,omitempty"`
+	ScopesSupported       []string `json:"scopes_supported,omitempty"`
+	IDTokenSigningAlgs    []string `json:"id_token_signing_alg_values_supported,omitempty"`
+}
+
+// WithOIDC enables the OpenID Connect mode, the endpoints will be discovered from the prefix,
+// and the id_token of the token response will be verified in callback.
+func WithOIDC() Option {
+	return func(c *Client) {
+		c.oidc = true
+	}
+}
+
+// NewWithDiscovery returns a Client in OpenID Connect mode after discovery
+func NewWithDiscovery(ctx context.Context, opts ...Option) (*Client, error) {
+	c := New(append(opts, WithOIDC())...)
+	if err := c.Discover(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Discover fetches the OpenID Provider Metadata and the JWK Set from the prefix,
+// and fills the endpoints of the client
+func (c *Client) Discover(ctx context.Context) error {
+	meta := new(ProviderMetadata)
+	if err := getJSON(ctx, c.httpClient, c.prefix+wellKnownPath, meta); err != nil {
+		return fmt.Errorf("oidc discovery: %w", err)
+	}
+	if meta.Issuer != c.prefix && meta.Issuer != c.prefix+"/" {
+		return fmt.Errorf("oidc discovery: issuer %q mismatch with %q", meta.Issuer, c.prefix)
+	}
+	if len(meta.AuthorizationEndpoint) == 0 || len(meta.TokenEndpoint) == 0 || len(meta.JwksURI) == 0 {
+		return fmt.Errorf("oidc discovery: incomplete metadata of %q", meta.Issuer)
+	}
+	ks := &keySet{uri: meta.JwksURI, hc: c.httpClient}
+	if err := ks.fetch(ctx); err != nil {
+		return fmt.Errorf("oidc jwks: %w", err)
+	}
+
+	c.oidc = true
+	c.meta = meta
+	c.keys = ks
+	c.conf.Endpoint.AuthURL = meta.AuthorizationEndpoint
+	c.conf.Endpoint.TokenURL = meta.TokenEndpoint
+	if len(meta.UserinfoEndpoint) > 0 {
+		c.infoURI = meta.UserinfoEndpoint
+	}
+	if !slices.Contains(c.conf.Scopes, "openid") {
+		c.conf.Scopes = append([]string{"openid"}, c.conf.Scopes...)
+	}
+	return nil
+}
+
+// Metadata returns the discovered OpenID Provider Metadata, nil if not in OIDC mode
+func (c *Client) Metadata() *ProviderMetadata {
+	return c.meta
+}
+
+// VerifyIDToken verifies the signature and the claims of a raw ID token,
+// nonce is checked only if it is not empty.
+func (c *Client) VerifyIDToken(ctx context.Context, raw, nonce string) (*IDClaims, error) {
+	if c.meta == nil || c.keys == nil {
+		return nil, fmt.Errorf("%w: provider not discovered", ErrInvalidIDToken)
+	}
+	claims := new(IDClaims)
+	if err := c.keys.verifyJWT(ctx, raw, claims); err != nil {
+		return nil, err
+	}
+	if err := claims.validate(c.meta.Issuer, c.conf.ClientID, nonce, time.Now()); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// verifyTokenID verifies the id_token in the token response of the code exchange.
+// The nonce is required if the state data was kept, since the auth request sent one then,
+// and an ID token with a nonce never sent is rejected.
+func (c *Client) verifyTokenID(ctx context.Context, tok *oauth2.Token, sd StateData) (*IDClaims, error) {
+	raw, _ := tok.Extra("id_token").(string)
+	if len(raw) == 0 {
+		return nil, ErrNoIDToken
+	}
+	if len(sd.Verifier) > 0 && len(sd.Nonce) == 0 {
+		return nil, fmt.Errorf("%w: nonce missing in state", ErrInvalidIDToken)
+	}
+	claims, err := c.VerifyIDToken(ctx, raw, sd.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	if len(sd.Nonce) == 0 && len(claims.Nonce) > 0 {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+	return claims, nil
+}
+
+// requestUserInfo calls the OIDC userinfo endpoint and converts the claims into an InfoToken
+func (c *Client) requestUserInfo(ctx context.Context, tok *oauth2.Token) (*InfoToken, error) {
+	claims := new(IDClaims)
+	if err := c.RequestWith(ctx, c.infoURI, tok, claims); err != nil {
+		return nil, err
+	}
+	return claims.withInfoToken(c.newInfoToken(tok)), nil
+}
+
+// InfoToken builds an InfoToken with the user and roles of claims
+func (c *IDClaims) InfoToken(tok *oauth2.Token) *InfoToken {
+	it := new(InfoToken)
+	if tok != nil {
+		it = NewInfoToken(tok)
+	}
+	return c.withInfoToken(it)
+}
+
+// withInfoToken sets the user and roles of claims to the InfoToken
+func (c *IDClaims) withInfoToken(it *InfoToken) *InfoToken {
+	it.User = c.ToO2User()
+	it.Roles = c.GetRoles()
+	return it
+}
+
+// IDClaimsFromContext returns the verified ID token claims of the callback request, only in OIDC mode
+func IDClaimsFromContext(ctx context.Context) *IDClaims {
+	if claims, ok := ctx.Value(IDClaimsKey).(*IDClaims); ok {
+		return claims
+	}
+	return nil
+}
+
+func getJSON(ctx context.Context, hc *http.Client, uri string, obj any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := hc.Do(req)
+	if err != nil {
+		slog.Info("get json fail", "err", err, "uri", uri)
+		return err
+	}
+	defer resp.Body.Close()
+	return decodeResponse(resp, obj)
+}
@@ -0,0 +1,271 @@
+package client
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+type testSigner struct {
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+	edKey  ed25519.PrivateKey
+}
+
+func newTestSigner(t *testing.T) *testSigner {
+	rk, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ek, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, dk, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	return &testSigner{rsaKey: rk, ecKey: ek, edKey: dk}
+}
+
+func b64(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+func (ts *testSigner) jwks() map[string]any {
+	ecx, ecy := make([]byte, 32), make([]byte, 32)
+	ts.ecKey.X.FillBytes(ecx)
+	ts.ecKey.Y.FillBytes(ecy)
+	return map[string]any{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "r1", "use": "sig", "n": b64(ts.rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(ts.rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": "e1", "crv": "P-256", "x": b64(ecx), "y": b64(ecy)},
+		{"kty": "OKP", "kid": "d1", "crv": "Ed25519", "x": b64(ts.edKey.Public().(ed25519.PublicKey))},
+	}}
+}
+
+func (ts *testSigner) sign(t *testing.T, alg, kid string, claims any) string {
+	hb, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	pb, _ := json.Marshal(claims)
+	signed := b64(hb) + "." + b64(pb)
+	h := sha256.Sum256([]byte(signed))
+	var sig []byte
+	switch alg {
+	case "RS256":
+		var err error
+		sig, err = rsa.SignPKCS1v15(rand.Reader, ts.rsaKey, crypto.SHA256, h[:])
+		require.NoError(t, err)
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, ts.ecKey, h[:])
+		require.NoError(t, err)
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	case "EdDSA":
+		sig = ed25519.Sign(ts.edKey, []byte(signed))
+	}
+	return signed + "." + b64(sig)
+}
+
+func newTestProvider(t *testing.T, ts *testSigner) *httptest.Server {
+	var srv *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc(wellKnownPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(ProviderMetadata{
+			Issuer:                srv.URL,
+			AuthorizationEndpoint: srv.URL + "/authorize",
+			TokenEndpoint:         srv.URL + "/token",
+			UserinfoEndpoint:      srv.URL + "/userinfo",
+			JwksURI:               srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/jwk-set+json")
+		_ = json.NewEncoder(w).Encode(ts.jwks())
+	})
+	srv = httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestClient_VerifyIDToken(t *testing.T) {
+	ts := newTestSigner(t)
+	srv := newTestProvider(t, ts)
+	c, err := NewWithDiscovery(context.Background(), WithPrefix(srv.URL), WithClientID("cid", "secret"))
+	require.NoError(t, err)
+	assert.Equal(t, srv.URL+"/token", c.Config().Endpoint.TokenURL)
+	assert.Equal(t, srv.URL+"/userinfo", c.infoURI)
+	assert.Contains(t, c.Config().Scopes, "openid")
+
+	now := time.Now().Unix()
+	good := map[string]any{"iss": srv.URL, "sub": "u1", "aud": "cid", "exp": now + 60, "iat": now,
+		"nonce": "n1", "preferred_username": "alice", "name": "Alice", "roles": []string{"admin"}}
+
+	for _, alg := range []string{"RS256", "ES256", "EdDSA"} {
+		t.Run(alg, func(t *testing.T) {
+			kid := map[string]string{"RS256": "r1", "ES256": "e1", "EdDSA": "d1"}[alg]
+			claims, err := c.VerifyIDToken(context.Background(), ts.sign(t, alg, kid, good), "n1")
+			require.NoError(t, err)
+			user := claims.ToO2User()
+			assert.Equal(t, "alice", user.UID)
+			assert.Equal(t, "u1", user.OID)
+			assert.True(t, user.Roles.Has("admin"))
+		})
+	}
+
+	bads := []struct {
+		name  string
+		key   string
+		value any
+		nonce string
+	}{
+		{"错误issuer", "iss", "https://evil.example.com", "n1"},
+		{"错误audience", "aud", []string{"other"}, "n1"},
+		{"已过期", "exp", now - 3600, "n1"},
+		{"nonce不匹配", "nonce", "n2", "n1"},
+	}
+	for _, tt := range bads {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := map[string]any{}
+			for k, v := range good {
+				claims[k] = v
+			}
+			claims[tt.key] = tt.value
+			_, err := c.VerifyIDToken(context.Background(), ts.sign(t, "RS256", "r1", claims), tt.nonce)
+			assert.ErrorIs(t, err, ErrInvalidIDToken)
+		})
+	}
+
+	raw := ts.sign(t, "RS256", "r1", good)
+	_, err = c.VerifyIDToken(context.Background(), raw[:len(raw)-4]+"AAAA", "n1")
+	assert.ErrorIs(t, err, ErrInvalidIDToken)
+
+	noNonce := map[string]any{}
+	for k, v := range good {
+		if k != "nonce" {
+			noNonce[k] = v
+		}
+	}
+	callbacks := []struct {
+		name    string
+		claims  map[string]any
+		sd      StateData
+		wantErr bool
+	}{
+		{"nonce一致", good, StateData{Verifier: "v", Nonce: "n1"}, false},
+		{"状态丢失nonce", good, StateData{Verifier: "v"}, true},
+		{"未发送nonce却返回", good, StateData{}, true},
+		{"均无nonce", noNonce, StateData{}, false},
+	}
+	for _, tt := range callbacks {
+		t.Run(tt.name, func(t *testing.T) {
+			tok := (&oauth2.Token{AccessToken: "at"}).WithExtra(map[string]any{"id_token": ts.sign(t, "RS256", "r1", tt.claims)})
+			_, err := c.verifyTokenID(context.Background(), tok, tt.sd)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidIDToken)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestDefaultE_RetryDiscovery(t *testing.T) {
+	ts := newTestSigner(t)
+	var down atomic.Bool
+	down.Store(true)
+	var srv *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc(wellKnownPath, func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(ProviderMetadata{Issuer: srv.URL, AuthorizationEndpoint: srv.URL + "/authorize",
+			TokenEndpoint: srv.URL + "/token", JwksURI: srv.URL + "/jwks"})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(ts.jwks())
+	})
+	srv = httptest.NewServer(mux)
+	defer srv.Close()
+
+	t.Setenv("OAUTH_PREFIX", srv.URL)
+	t.Setenv("OAUTH_CLIENT_ID", "cid")
+	t.Setenv("OAUTH_CLIENT_SECRET", "secret")
+	t.Setenv("OAUTH_OIDC", "true")
+	old := dftClient.Load()
+	SetDefault(nil)
+	defer SetDefault(old)
+
+	_, err := DefaultE()
+	assert.Error(t, err)
+	assert.Nil(t, dftClient.Load(), "not kept")
+
+	// Default serves an undiscovered client and retries after the interval only
+	c0 := Default()
+	require.NotNil(t, c0)
+	assert.Nil(t, c0.Metadata())
+	store := NewMemoryTokenStore()
+	RegisterTokenStore(store)
+	down.Store(false)
+	assert.Same(t, c0, Default(), "retry later")
+
+	c, err := DefaultE()
+	require.NoError(t, err)
+	assert.NotSame(t, c0, c)
+	assert.NotNil(t, c.Metadata())
+	assert.Same(t, store, c.TokenStore(), "stores are kept")
+	assert.Same(t, c, Default())
+}
+
+func TestKeySet_RefetchInterval(t *testing.T) {
+	ts := newTestSigner(t)
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Content-Type", "application/jwk-set+json")
+		_ = json.NewEncoder(w).Encode(ts.jwks())
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	ks := &keySet{uri: srv.URL, hc: srv.Client()}
+	require.NoError(t, ks.fetch(ctx))
+	found, err := ks.lookup(ctx, "r1", "RSA")
+	require.NoError(t, err)
+	assert.Len(t, found, 1)
+
+	// unknown kids do not refetch within the interval
+	for range 10 {
+		_, err = ks.lookup(ctx, "unknown", "RSA")
+		assert.ErrorIs(t, err, ErrInvalidIDToken)
+	}
+	assert.EqualValues(t, 1, hits.Load())
+
+	ks.mu.Lock()
+	ks.fetched = time.Now().Add(-jwksRefetchInterval)
+	ks.mu.Unlock()
+	done := make(chan struct{})
+	for range 5 {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			_, _ = ks.lookup(ctx, "unknown", "RSA")
+		}()
+	}
+	for range 5 {
+		<-done
+	}
+	assert.EqualValues(t, 2, hits.Load(), "once after the interval")
+}
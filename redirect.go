@@ -0,0 +1,76 @@
+package client
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// ParamReturnTo is the query parameter of login path for the URL to return after signed in
+const ParamReturnTo = "return_to"
+
+// WithAllowedHosts sets the hosts allowed in return URLs besides the request host,
+// a host starts with "." matches all of its subdomains.
+func WithAllowedHosts(hosts ...string) Option {
+	return func(c *Client) {
+		c.allowedHosts = append(c.allowedHosts, hosts...)
+	}
+}
+
+// LoginURL returns the login path with the return URL
+func (c *Client) LoginURL(returnTo string) string {
+	lp := c.LoginPath()
+	if len(returnTo) == 0 {
+		return lp
+	}
+	sep := "?"
+	if strings.Contains(lp, "?") {
+		sep = "&"
+	}
+	return lp + sep + ParamReturnTo + "=" + url.QueryEscape(returnTo)
+}
+
+// returnTo returns the URL saved with the state if it is allowed, otherwise the admin path
+func (c *Client) returnTo(r *http.Request) string {
+	if sd, ok := StateDataFromContext(r.Context()); ok {
+		if s := c.safeReturnTo(r, sd.ReturnTo); len(s) > 0 {
+			return s
+		}
+	}
+	return c.AdminPath()
+}
+
+// safeReturnTo guards against open redirect, returns s if it is a local path,
+// or an absolute URL of the request host or allowed hosts, otherwise returns empty.
+func (c *Client) safeReturnTo(r *http.Request, s string) string {
+	if len(s) == 0 || strings.ContainsAny(s, "\\\r\n\t") {
+		return ""
+	}
+	if isLocalPath(s) {
+		return s
+	}
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || len(u.Host) == 0 || u.User != nil {
+		return ""
+	}
+	if strings.EqualFold(u.Host, r.Host) || c.isAllowedHost(u.Hostname()) {
+		return u.String()
+	}
+	return ""
+}
+
+func (c *Client) isAllowedHost(host string) bool {
+	host = strings.ToLower(host)
+	return slices.ContainsFunc(c.allowedHosts, func(ah string) bool {
+		ah = strings.ToLower(ah)
+		if strings.HasPrefix(ah, ".") {
+			return strings.HasSuffix(host, ah)
+		}
+		return host == ah
+	})
+}
+
+func isLocalPath(s string) bool {
+	return strings.HasPrefix(s, "/") && !strings.HasPrefix(s, "//") && !strings.HasPrefix(s, "/\\")
+}
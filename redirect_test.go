@@ -0,0 +1,85 @@
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_SafeReturnTo(t *testing.T) {
+	c := New(WithAllowedHosts("app.example.com", ".corp.example.com"))
+	r := httptest.NewRequest(http.MethodGet, "http://self.example.com/auth/login", nil)
+
+	tests := []struct {
+		name     string
+		in       string
+		expected string
+	}{
+		{"本地路径", "/admin/users?id=1", "/admin/users?id=1"},
+		{"空", "", ""},
+		{"协议相对", "//evil.example.com/", ""},
+		{"反斜杠", "/\\evil.example.com", ""},
+		{"同源", "http://self.example.com/x", "http://self.example.com/x"},
+		{"白名单", "https://app.example.com/x", "https://app.example.com/x"},
+		{"白名单子域", "https://a.corp.example.com/x", "https://a.corp.example.com/x"},
+		{"外部域名", "https://evil.example.com/x", ""},
+		{"后缀欺骗", "https://evilcorp.example.com.attacker.io/", ""},
+		{"javascript", "javascript:alert(1)", ""},
+		{"含用户信息", "https://app.example.com@evil.example.com/", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, c.safeReturnTo(r, tt.in))
+		})
+	}
+}
+
+func TestClient_ReturnToRoundTrip(t *testing.T) {
+	c := New(WithPaths("/admin/", "/auth/login"))
+
+	// unauthenticated request is redirected to login with return_to
+	rec := httptest.NewRecorder()
+	h := c.AuthMiddleware(true)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/reports?m=3", nil))
+	assert.Equal(t, http.StatusFound, rec.Code)
+	loc := rec.Header().Get("Location")
+	assert.Equal(t, "/auth/login?return_to=%2Fadmin%2Freports%3Fm%3D3", loc)
+
+	// AJAX and non-GET requests are not redirected
+	ajax := httptest.NewRequest(http.MethodGet, "/admin/reports", nil)
+	ajax.Header.Set("Accept", "application/json")
+	for _, r := range []*http.Request{ajax, httptest.NewRequest(http.MethodPost, "/admin/reports", nil)} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code, r.Method)
+		assert.Empty(t, rec.Header().Get("Location"))
+	}
+	// rendered by the ErrorHandler
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, ajax)
+	var ie InfoError
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&ie))
+	assert.Equal(t, "unauthorized", ie.ErrCode)
+
+	// login keeps return_to with the state
+	rec = httptest.NewRecorder()
+	authURL := c.LoginStart(rec, httptest.NewRequest(http.MethodGet, loc, nil))
+	u, err := url.Parse(authURL)
+	require.NoError(t, err)
+	sd, ok := c.stateVerify(requestWithCookies(rec), u.Query().Get("state"))
+	require.True(t, ok)
+	assert.Equal(t, "/admin/reports?m=3", sd.ReturnTo)
+
+	// an external return_to is dropped
+	rec = httptest.NewRecorder()
+	authURL = c.LoginStart(rec, httptest.NewRequest(http.MethodGet, c.LoginURL("https://evil.example.com/"), nil))
+	u, _ = url.Parse(authURL)
+	sd, ok = c.stateVerify(requestWithCookies(rec), u.Query().Get("state"))
+	require.True(t, ok)
+	assert.Empty(t, sd.ReturnTo)
+}
@@ -0,0 +1,179 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// ErrNoRefreshToken is returned when refreshing an InfoToken without refresh token
+var ErrNoRefreshToken = errors.New("refresh token not found")
+
+// tokenExpiryDelta is how early a token is refreshed before it expires, same as oauth2
+const tokenExpiryDelta = 10 * time.Second
+
+// RefreshInfoToken exchanges the refresh token of it at the token endpoint, and re-fetches
+// the info and the roles with the new access token. A rotated refresh token is kept,
+// otherwise the old one is carried over.
+// If the info request fails after the exchange, an InfoToken with only the new token is returned
+// with the error, the caller should persist it since the old refresh token may be rotated out.
+func (c *Client) RefreshInfoToken(ctx context.Context, it *InfoToken, roles ...string) (*InfoToken, error) {
+	if len(it.RefreshToken) == 0 {
+		return nil, ErrNoRefreshToken
+	}
+	ctxEx := context.WithValue(ctx, oauth2.HTTPClient, c.httpClient)
+	expired := &oauth2.Token{RefreshToken: it.RefreshToken, Expiry: time.Now().Add(-time.Second)}
+	tok, err := c.conf.TokenSource(ctxEx, expired).Token()
+	if err != nil {
+		slog.Info("refresh token fail", "err", err)
+		return nil, AsOAuthError(err)
+	}
+	uid := it.uid()
+	c.tel().refreshes.Add(ctx, 1)
+	c.audit(ctx, nil, AuditEvent{Type: AuditTokenRefreshed, UID: uid})
+	_ = c.InvalidateInfo(ctx, it.AccessToken)
+
+	ot := c.newInfoToken(tok)
+	if len(ot.RefreshToken) == 0 {
+		ot.RefreshToken = it.RefreshToken
+	}
+	nit, err := c.RequestInfoToken(ctx, tok, roles...)
+	if err != nil {
+		return ot, err
+	}
+	nit.AccessToken, nit.TokenType, nit.RefreshToken = ot.AccessToken, ot.TokenType, ot.RefreshToken
+	if !ot.Expiry.IsZero() {
+		nit.Expiry, nit.ExpiresIn = ot.Expiry, ot.ExpiresIn
+	}
+	return nit, nil
+}
+
+// InfoTokenSource is an oauth2.TokenSource of an InfoToken, which refreshes it with the info
+// and the roles when the access token expires.
+type InfoTokenSource struct {
+	// OnRefresh is called with the refreshed InfoToken, e.g. to persist the rotated refresh token,
+	// also when the info request fails after the exchange, with the info before and the new token.
+	OnRefresh func(it *InfoToken)
+
+	c     *Client
+	ctx   context.Context
+	roles []string
+
+	mu sync.Mutex
+	it *InfoToken
+}
+
+var _ oauth2.TokenSource = (*InfoTokenSource)(nil)
+
+// InfoTokenSource returns an InfoTokenSource of it, refreshed infos are requested with the roles
+func (c *Client) InfoTokenSource(ctx context.Context, it *InfoToken, roles ...string) *InfoTokenSource {
+	return &InfoTokenSource{c: c, ctx: ctx, roles: roles, it: it}
+}
+
+// Token returns the access token, refreshes it if expired
+func (s *InfoTokenSource) Token() (*oauth2.Token, error) {
+	it, err := s.InfoToken()
+	if err != nil {
+		return nil, err
+	}
+	return it.OAuth2Token(), nil
+}
+
+// InfoToken returns the current InfoToken, refreshes it if expired
+func (s *InfoTokenSource) InfoToken() (*InfoToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.it.Expiry.IsZero() || time.Now().Add(tokenExpiryDelta).Before(s.it.Expiry) {
+		return s.it, nil
+	}
+	it, err := s.c.RefreshInfoToken(s.ctx, s.it, s.roles...)
+	if err != nil {
+		if it == nil {
+			return nil, err
+		}
+		// the token is refreshed but the info is not, keep the rotated token with the info before
+		nit := s.it.clone()
+		nit.AccessToken, nit.TokenType, nit.RefreshToken = it.AccessToken, it.TokenType, it.RefreshToken
+		nit.Expiry, nit.ExpiresIn = it.Expiry, it.ExpiresIn
+		it = nit
+	}
+	s.it = it
+	if s.OnRefresh != nil {
+		s.OnRefresh(it)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return it, nil
+}
+
+// WithRoleRevalidation re-validates the roles of the signed in user at the provider when the session
+// cookie is refreshed (see WithRefresh), with the token in the TokenStore, so that revoked roles take
+// effect before the cookie expires. The roles are requested, empty means the roles of the user.
+// The session ends if the token is rejected, and is kept on transient errors.
+func WithRoleRevalidation(roles ...string) Option {
+	return func(c *Client) {
+		c.revalidate = true
+		c.revalidateRoles = roles
+	}
+}
+
+// revalidateUser updates the roles of the user with the stored token, concurrent calls of the same user
+// share one request, returns an error only if the provider rejects the token.
+func (c *Client) revalidateUser(ctx context.Context, user *User) error {
+	roles := c.revalidateRoles
+	if len(roles) == 0 {
+		roles = user.Roles
+	}
+	v, err, _ := c.revalidateGroup.Do(user.UID, func() (any, error) {
+		return c.revalidateToken(context.WithoutCancel(ctx), user.UID, roles)
+	})
+	if err != nil {
+		return err
+	}
+	if it, _ := v.(*InfoToken); it != nil {
+		user.Roles = slices.Clone(it.Roles)
+	}
+	return nil
+}
+
+// revalidateToken requests the info with the stored token of the user, nil if no token is stored
+// or on transient errors. A rejected token is checked against the store again,
+// since another request or instance may have rotated it meanwhile.
+func (c *Client) revalidateToken(ctx context.Context, uid string, roles []string) (*InfoToken, error) {
+	store := c.TokenStore()
+	if store == nil {
+		return nil, nil
+	}
+	var used *oauth2.Token
+	var rejected error
+	for range 2 {
+		stored, err := store.Get(ctx, uid)
+		if err != nil {
+			slog.Info("skip revalidation, token not found", "uid", uid, "err", err)
+			return nil, nil
+		}
+		if used != nil && stored.AccessToken == used.AccessToken && stored.RefreshToken == used.RefreshToken {
+			break
+		}
+		var tok *oauth2.Token
+		if tok, err = c.storedTokenSource(ctx, store, uid, stored).Token(); err == nil {
+			_ = c.InvalidateInfo(ctx, tok.AccessToken)
+			var it *InfoToken
+			if it, err = c.RequestInfoToken(ctx, tok, roles...); err == nil {
+				return it, nil
+			}
+		}
+		if AsOAuthError(err).GetStatus() >= 500 {
+			slog.Info("revalidation fail, keep the session", "uid", uid, "err", err)
+			return nil, nil
+		}
+		used, rejected = stored, err
+	}
+	return nil, rejected
+}
@@ -0,0 +1,241 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+
+	auth "github.com/liut/simpauth"
+)
+
+// newRefreshProvider serves refresh grants with rotation, and info with the roles of the access token
+func newRefreshProvider(t *testing.T, roles *[]string) (*httptest.Server, *atomic.Int32) {
+	var n atomic.Int32
+	var mu sync.Mutex
+	valid := map[string]bool{"rt0": true}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		assert.Equal(t, "refresh_token", r.PostForm.Get("grant_type"))
+		w.Header().Set("Content-Type", "application/json")
+		mu.Lock()
+		defer mu.Unlock()
+		rt := r.PostForm.Get("refresh_token")
+		if !valid[rt] {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		delete(valid, rt)
+		i := n.Add(1)
+		valid[fmt.Sprintf("rt%d", i)] = true
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token": fmt.Sprintf("at%d", i), "token_type": "Bearer", "expires_in": 3600,
+			"refresh_token": fmt.Sprintf("rt%d", i),
+		})
+	})
+	mux.HandleFunc("/info/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token": BearerToken(r), "me": map[string]string{"uid": "alice"}, "group": *roles,
+		})
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv, &n
+}
+
+func TestClient_RefreshInfoToken(t *testing.T) {
+	roles := []string{"admin"}
+	srv, hits := newRefreshProvider(t, &roles)
+	c := New(WithPrefix(srv.URL), WithClientID("cid", "secret"))
+	ctx := context.Background()
+
+	_, err := c.RefreshInfoToken(ctx, &InfoToken{AccessToken: "at0"})
+	assert.ErrorIs(t, err, ErrNoRefreshToken)
+
+	old := &InfoToken{AccessToken: "at0", RefreshToken: "rt0", Expiry: time.Now().Add(-time.Minute), Roles: []string{"admin"}}
+	var refreshed []*InfoToken
+	ts := c.InfoTokenSource(ctx, old, "admin")
+	ts.OnRefresh = func(it *InfoToken) { refreshed = append(refreshed, it) }
+
+	tok, err := ts.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "at1", tok.AccessToken)
+	assert.Equal(t, "rt1", tok.RefreshToken, "rotated")
+	tok, err = ts.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "at1", tok.AccessToken, "not expired")
+	require.Len(t, refreshed, 1)
+	assert.True(t, refreshed[0].HasRole("admin"))
+	assert.EqualValues(t, 1, hits.Load())
+
+	// the rotated-out refresh token is rejected
+	_, err = c.RefreshInfoToken(ctx, old)
+	assert.ErrorIs(t, err, ErrInvalidGrant)
+
+	// roles revoked at the provider
+	roles = nil
+	it := refreshed[0].clone()
+	it.Expiry = time.Now()
+	ts = c.InfoTokenSource(ctx, it, "admin")
+	it, err = ts.InfoToken()
+	require.NoError(t, err)
+	assert.False(t, it.HasRole("admin"))
+	assert.Equal(t, "rt2", it.RefreshToken)
+}
+
+func TestClient_RoleRevalidation(t *testing.T) {
+	roles := []string{"admin"}
+	srv, _ := newRefreshProvider(t, &roles)
+	store := NewMemoryTokenStore()
+	c := New(WithPrefix(srv.URL), WithClientID("cid", "secret"), WithTokenStore(store), WithRoleRevalidation())
+	ctx := context.Background()
+	require.NoError(t, store.Put(ctx, "alice", &oauth2.Token{AccessToken: "at0", RefreshToken: "rt0", Expiry: time.Now().Add(-time.Minute)}))
+
+	var seen *User
+	h := c.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, _ := UserFromContext(r.Context())
+		seen, _ = u.(*User)
+	}))
+	// a session which needs refresh, half of the lifetime passed
+	signin := func(lastHit int64) *http.Request {
+		rec := httptest.NewRecorder()
+		require.NoError(t, c.Signin(&User{UID: "alice", Roles: []string{"admin"}, LastHit: lastHit}, rec))
+		return requestWithCookies(rec)
+	}
+	old := time.Now().Unix() - auth.DefaultLifetime*3/4
+
+	roles = nil
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, signin(old))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, seen.Roles, "role revoked")
+	assert.NotEmpty(t, rec.Result().Cookies(), "cookie refreshed")
+	tok, err := store.Get(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, "rt1", tok.RefreshToken, "rotated token persisted")
+
+	// a fresh session is not revalidated
+	seen = nil
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, signin(time.Now().Unix()))
+	assert.Equal(t, []string{"admin"}, []string(seen.Roles))
+
+	// the token is rejected
+	require.NoError(t, store.Put(ctx, "alice", &oauth2.Token{AccessToken: "x", RefreshToken: "bad", Expiry: time.Now().Add(-time.Minute)}))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, signin(old))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestInfoTokenSource_InfoFail(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "at1", "token_type": "Bearer", "expires_in": 3600, "refresh_token": "rt1",
+		})
+	})
+	mux.HandleFunc("/info/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	c := New(WithPrefix(srv.URL), WithClientID("cid", "secret"))
+	ctx := context.Background()
+	old := &InfoToken{AccessToken: "at0", RefreshToken: "rt0", Expiry: time.Now().Add(-time.Minute), Roles: []string{"admin"}}
+
+	it, err := c.RefreshInfoToken(ctx, old)
+	assert.Error(t, err)
+	require.NotNil(t, it, "rotated token returned with the error")
+	assert.Equal(t, "rt1", it.RefreshToken)
+
+	var saved *InfoToken
+	ts := c.InfoTokenSource(ctx, old)
+	ts.OnRefresh = func(it *InfoToken) { saved = it }
+	_, err = ts.Token()
+	assert.Error(t, err)
+	require.NotNil(t, saved)
+	assert.Equal(t, "rt1", saved.RefreshToken, "rotated token persisted")
+	assert.True(t, saved.HasRole("admin"), "info before kept")
+	tok, err := ts.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "at1", tok.AccessToken)
+}
+
+// staleStore returns the stale token on the first Get, like a read before another instance rotated it
+type staleStore struct {
+	TokenStore
+	stale *oauth2.Token
+	once  sync.Once
+}
+
+func (s *staleStore) Get(ctx context.Context, key string) (tok *oauth2.Token, err error) {
+	s.once.Do(func() { tok = s.stale })
+	if tok != nil {
+		return tok, nil
+	}
+	return s.TokenStore.Get(ctx, key)
+}
+
+func TestClient_RoleRevalidation_Rotated(t *testing.T) {
+	roles := []string{"admin"}
+	srv, hits := newRefreshProvider(t, &roles)
+	ctx := context.Background()
+	old := time.Now().Unix() - auth.DefaultLifetime*3/4
+	expired := time.Now().Add(-time.Minute)
+
+	t.Run("并发请求只刷新一次", func(t *testing.T) {
+		store := NewMemoryTokenStore()
+		c := New(WithPrefix(srv.URL), WithClientID("cid", "secret"), WithTokenStore(store), WithRoleRevalidation())
+		require.NoError(t, store.Put(ctx, "alice", &oauth2.Token{AccessToken: "at0", RefreshToken: "rt0", Expiry: expired}))
+		rec := httptest.NewRecorder()
+		require.NoError(t, c.Signin(&User{UID: "alice", Roles: []string{"admin"}, LastHit: old}, rec))
+
+		release := make(chan struct{})
+		h := c.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		var wg sync.WaitGroup
+		codes := make([]int, 4)
+		for i := range codes {
+			r := requestWithCookies(rec)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-release
+				rr := httptest.NewRecorder()
+				h.ServeHTTP(rr, r)
+				codes[i] = rr.Code
+			}()
+		}
+		close(release)
+		wg.Wait()
+		assert.Equal(t, []int{200, 200, 200, 200}, codes)
+		assert.EqualValues(t, 1, hits.Load())
+	})
+
+	t.Run("其他实例已轮换令牌", func(t *testing.T) {
+		// rt1 was rotated to rt2 by another instance, which stored it
+		mem := NewMemoryTokenStore()
+		store := &staleStore{TokenStore: mem, stale: &oauth2.Token{AccessToken: "at1", RefreshToken: "rt1", Expiry: expired}}
+		c := New(WithPrefix(srv.URL), WithClientID("cid", "secret"), WithTokenStore(store), WithRoleRevalidation())
+		other, err := c.RefreshInfoToken(ctx, &InfoToken{RefreshToken: "rt1"})
+		require.NoError(t, err)
+		require.NoError(t, mem.Put(ctx, "alice", other.OAuth2Token()))
+
+		user := &User{UID: "alice", Roles: []string{"admin"}, LastHit: old}
+		require.NoError(t, c.revalidateUser(ctx, user), "not signed out")
+		assert.Equal(t, []string{"admin"}, []string(user.Roles))
+	})
+}
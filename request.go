@@ -0,0 +1,109 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// RequestOption customizes a request of Do
+type RequestOption func(req *http.Request)
+
+// RequestHeader sets a header of the request
+func RequestHeader(key, value string) RequestOption {
+	return func(req *http.Request) {
+		req.Header.Set(key, value)
+	}
+}
+
+// RequestQuery adds the query params to the request URI
+func RequestQuery(params url.Values) RequestOption {
+	return func(req *http.Request) {
+		q := req.URL.Query()
+		for k, vs := range params {
+			for _, v := range vs {
+				q.Add(k, v)
+			}
+		}
+		req.URL.RawQuery = q.Encode()
+	}
+}
+
+// Do performs an HTTP request with the OAuth2 token and unmarshals the JSON response into out.
+// See (*Client).Do for the body types.
+func Do(ctx context.Context, method, uri string, tok *oauth2.Token, body, out any, opts ...RequestOption) error {
+	return Default().Do(ctx, method, uri, tok, body, out, opts...)
+}
+
+// Do performs an HTTP request with the OAuth2 token and unmarshals the JSON response into out,
+// out may be nil to discard the response. The body is encoded by its type:
+// nil for no body, url.Values as a form, io.Reader or []byte as is, and others as JSON.
+// An io.Reader other than bytes.Reader, bytes.Buffer and strings.Reader is read into memory first,
+// so that the retry transport can resend it.
+// A nil tok sends the request without Authorization. Errors are the same as RequestWith.
+func (c *Client) Do(ctx context.Context, method, uri string, tok *oauth2.Token, body, out any, opts ...RequestOption) error {
+	rb, ct, err := encodeBody(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, uri, rb)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if len(ct) > 0 {
+		req.Header.Set("Content-Type", ct)
+	}
+	for _, opt := range opts {
+		opt(req)
+	}
+
+	hc := c.httpClient
+	if tok != nil {
+		hc = c.conf.Client(context.WithValue(ctx, oauth2.HTTPClient, c.httpClient), tok)
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		slog.Info("request fail", "err", err, "method", method, "uri", uri)
+		return err
+	}
+	defer resp.Body.Close()
+	err = decodeResponse(resp, out)
+	if err != nil {
+		slog.Info("decode resp fail", "err", err, "sc", resp.StatusCode, "method", method, "uri", uri)
+		return err
+	}
+	return nil
+}
+
+func encodeBody(body any) (io.Reader, string, error) {
+	switch b := body.(type) {
+	case nil:
+		return nil, "", nil
+	case url.Values:
+		return strings.NewReader(b.Encode()), "application/x-www-form-urlencoded", nil
+	case *bytes.Reader, *bytes.Buffer, *strings.Reader:
+		return b.(io.Reader), "", nil
+	case io.Reader:
+		// buffered, so that the request can be replayed by the retry transport
+		data, err := io.ReadAll(b)
+		if err != nil {
+			return nil, "", err
+		}
+		return bytes.NewReader(data), "", nil
+	case []byte:
+		return bytes.NewReader(b), "", nil
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, "", err
+	}
+	return bytes.NewReader(data), "application/json", nil
+}
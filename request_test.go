@@ -0,0 +1,105 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+type echoResult struct {
+	Method string `json:"method"`
+	Type   string `json:"type"`
+	Auth   string `json:"auth"`
+	Query  string `json:"query"`
+	Trace  string `json:"trace"`
+	Body   string `json:"body"`
+}
+
+func TestClient_Do(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(echoResult{
+			Method: r.Method,
+			Type:   r.Header.Get("Content-Type"),
+			Auth:   r.Header.Get("Authorization"),
+			Query:  r.URL.RawQuery,
+			Trace:  r.Header.Get("X-Trace"),
+			Body:   string(b),
+		})
+	}))
+	defer srv.Close()
+
+	c := New(WithPrefix(srv.URL))
+	tok := &oauth2.Token{AccessToken: "at", TokenType: "Bearer"}
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		method string
+		body   any
+		opts   []RequestOption
+		want   echoResult
+	}{
+		{"JSON 请求体", http.MethodPost, map[string]string{"name": "eagle"}, nil,
+			echoResult{Method: "POST", Type: "application/json", Body: `{"name":"eagle"}`}},
+		{"表单请求体", http.MethodPut, url.Values{"name": {"eagle"}}, nil,
+			echoResult{Method: "PUT", Type: "application/x-www-form-urlencoded", Body: "name=eagle"}},
+		{"原始请求体与头", http.MethodPatch, strings.NewReader("raw"),
+			[]RequestOption{RequestHeader("Content-Type", "text/plain"), RequestHeader("X-Trace", "t1")},
+			echoResult{Method: "PATCH", Type: "text/plain", Trace: "t1", Body: "raw"}},
+		{"查询参数", http.MethodGet, nil, []RequestOption{RequestQuery(url.Values{"q": {"a b"}})},
+			echoResult{Method: "GET", Query: "page=1&q=a+b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got echoResult
+			require.NoError(t, c.Do(ctx, tt.method, srv.URL+"/items?page=1", tok, tt.body, &got, tt.opts...))
+			tt.want.Auth = "Bearer at"
+			if len(tt.want.Query) == 0 {
+				tt.want.Query = "page=1"
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	assert.NoError(t, c.Do(ctx, http.MethodDelete, srv.URL+"/items/1", tok, nil, nil))
+
+	var got echoResult
+	require.NoError(t, c.Do(ctx, http.MethodGet, srv.URL, nil, nil, &got))
+	assert.Empty(t, got.Auth)
+}
+
+func TestClient_Do_RetryReader(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		assert.Equal(t, "raw", string(b))
+		if hits.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	c := New(WithRetry(RetryPolicy{MinBackoff: time.Millisecond, FailureThreshold: -1}))
+	body := io.MultiReader(strings.NewReader("raw")) // no GetBody of its own
+	require.NoError(t, c.Do(context.Background(), http.MethodPost, srv.URL, nil, body, nil))
+	assert.EqualValues(t, 2, hits.Load())
+}
@@ -0,0 +1,102 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+)
+
+var (
+	// MaxResponseSize is the max size of a response body to decode
+	MaxResponseSize int64 = 4 << 20
+	// maxErrorBody is the max size of the body kept in ResponseError
+	maxErrorBody = 512
+)
+
+// ResponseError is returned for a non-2xx or a non-JSON response of the provider or resource servers
+type ResponseError struct {
+	StatusCode  int
+	ContentType string
+	// Body is the head of the response body, truncated to 512 bytes
+	Body string
+	// OAuth is the decoded RFC 6749 error of the body, nil if not present
+	OAuth *OAuthError
+}
+
+func (e *ResponseError) Error() string {
+	s := fmt.Sprintf("unexpected response %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	if e.OAuth != nil {
+		return s + ": " + e.OAuth.Error()
+	}
+	if len(e.Body) > 0 {
+		s += ": " + e.Body
+	}
+	return s
+}
+
+// Unwrap returns the OAuthError of the body, so that errors.Is matches the sentinel errors
+func (e *ResponseError) Unwrap() error {
+	if e.OAuth != nil {
+		return e.OAuth
+	}
+	return nil
+}
+
+func isJSONType(ct string) bool {
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+	return mt == "application/json" || (len(mt) > 5 && mt[len(mt)-5:] == "+json")
+}
+
+// decodeResponse checks the status and the content type of the response,
+// and decodes the JSON body into obj, a body larger than MaxResponseSize is an error.
+func decodeResponse(resp *http.Response, obj any) error {
+	ct := resp.Header.Get("Content-Type")
+	body := io.LimitReader(resp.Body, MaxResponseSize+1)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 || (len(ct) > 0 && !isJSONType(ct)) {
+		return newResponseError(resp.StatusCode, ct, body)
+	}
+	if obj == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	if int64(len(b)) > MaxResponseSize {
+		return fmt.Errorf("response body exceeds %d bytes", MaxResponseSize)
+	}
+	if len(bytes.TrimSpace(b)) == 0 {
+		return nil
+	}
+	return json.Unmarshal(b, obj)
+}
+
+func newResponseError(status int, ct string, body io.Reader) *ResponseError {
+	b, _ := io.ReadAll(io.LimitReader(body, 64<<10))
+	re := &ResponseError{StatusCode: status, ContentType: ct}
+	if isJSONType(ct) {
+		var oe OAuthError
+		if err := json.Unmarshal(b, &oe); err == nil && len(oe.Code) > 0 {
+			oe.Status = status
+			re.OAuth = &oe
+		}
+	}
+	if len(b) > maxErrorBody {
+		b = b[:maxErrorBody]
+	}
+	re.Body = string(b)
+	return re
+}
+
+// IsResponseStatus checks whether err is a ResponseError with the status code
+func IsResponseStatus(err error, status int) bool {
+	var re *ResponseError
+	return errors.As(err, &re) && re.StatusCode == status
+}
@@ -0,0 +1,89 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestRequestWith_Status(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			_, _ = w.Write([]byte(`{"id":"1","title":"hello"}`))
+		case "/grant":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_grant","error_description":"token expired"}`))
+		case "/html":
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte("<html>" + strings.Repeat("x", 2048) + "</html>"))
+		case "/empty":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "boom", http.StatusBadGateway)
+		}
+	}))
+	defer srv.Close()
+
+	c := New(WithPrefix(srv.URL))
+	tok := &oauth2.Token{AccessToken: "at", TokenType: "Bearer"}
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+		oauth  string
+	}{
+		{"正常响应", "/ok", 0, ""},
+		{"无内容", "/empty", 0, ""},
+		{"RFC 6749 错误", "/grant", http.StatusBadRequest, "invalid_grant"},
+		{"非 JSON 类型", "/html", http.StatusOK, ""},
+		{"服务端错误", "/fail", http.StatusBadGateway, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var obj struct {
+				Title string `json:"title"`
+			}
+			err := c.RequestWith(ctx, srv.URL+tt.path, tok, &obj)
+			if tt.status == 0 {
+				require.NoError(t, err)
+				return
+			}
+			var re *ResponseError
+			require.True(t, errors.As(err, &re))
+			assert.Equal(t, tt.status, re.StatusCode)
+			assert.True(t, IsResponseStatus(err, tt.status))
+			assert.LessOrEqual(t, len(re.Body), 512)
+			if len(tt.oauth) > 0 {
+				assert.ErrorIs(t, err, ErrInvalidGrant)
+				assert.Equal(t, "token expired", re.OAuth.Description)
+			} else {
+				assert.Nil(t, re.OAuth)
+				assert.Equal(t, http.StatusBadGateway, AsOAuthError(err).GetStatus())
+			}
+		})
+	}
+}
+
+func TestDecodeResponse_Limit(t *testing.T) {
+	old := MaxResponseSize
+	MaxResponseSize = 16
+	defer func() { MaxResponseSize = old }()
+
+	rec := httptest.NewRecorder()
+	rec.Header().Set("Content-Type", "application/json")
+	_, _ = rec.WriteString(`{"title":"` + strings.Repeat("x", 32) + `"}`)
+	var obj map[string]any
+	assert.Error(t, decodeResponse(rec.Result(), &obj))
+}
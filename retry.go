@@ -0,0 +1,244 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned when the provider keeps failing and calls are rejected without trying
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// RetryPolicy configures the retries and the circuit breaker of calls to the provider
+type RetryPolicy struct {
+	// MaxRetries is the max retries after the first attempt, default 2, negative disables retries
+	// while the circuit breaker still applies.
+	MaxRetries int
+	// MinBackoff is the backoff of the first retry, doubled with jitter for the next ones, default 200ms.
+	MinBackoff time.Duration
+	// MaxBackoff caps the backoff, and a longer Retry-After is not waited, default 5s.
+	MaxBackoff time.Duration
+	// Timeout is the timeout of each attempt, default 9s.
+	// The total time is driven by the request context.
+	Timeout time.Duration
+	// FailureThreshold is the consecutive failures to open the circuit, default 5, negative disables it.
+	FailureThreshold int
+	// Cooldown is how long the circuit stays open before a trial call, default 30s.
+	Cooldown time.Duration
+}
+
+// WithRetry wraps the http client to the provider with a RetryTransport,
+// it applies to the client set by WithHTTPClient or WithTLSConfig too.
+func WithRetry(p RetryPolicy) Option {
+	return func(c *Client) {
+		c.retry = &p
+	}
+}
+
+// RetryTransport retries failed requests with exponential backoff and jitter, honoring Retry-After,
+// and fails fast with ErrCircuitOpen when the provider is down.
+// Idempotent requests are retried on network errors, 429 and 5xx,
+// others only on 429 and 503 which mean the request is not processed.
+type RetryTransport struct {
+	Base   http.RoundTripper
+	Policy RetryPolicy
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	trialing bool
+}
+
+// NewRetryTransport returns a RetryTransport over base, nil means http.DefaultTransport
+func NewRetryTransport(base http.RoundTripper, p RetryPolicy) *RetryTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if p.MaxRetries == 0 {
+		p.MaxRetries = 2
+	} else if p.MaxRetries < 0 {
+		p.MaxRetries = 0
+	}
+	p.MinBackoff = durationOr(p.MinBackoff, 200*time.Millisecond)
+	p.MaxBackoff = durationOr(p.MaxBackoff, 5*time.Second)
+	p.Timeout = durationOr(p.Timeout, defaultTimeout)
+	if p.FailureThreshold == 0 {
+		p.FailureThreshold = 5
+	}
+	p.Cooldown = durationOr(p.Cooldown, 30*time.Second)
+	return &RetryTransport{Base: base, Policy: p}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.allow() {
+		return nil, ErrCircuitOpen
+	}
+	ctx := req.Context()
+	for attempt := 0; ; attempt++ {
+		resp, err := t.try(req)
+		failed := (err != nil && ctx.Err() == nil) || (err == nil && resp.StatusCode >= 500)
+		if attempt >= t.Policy.MaxRetries || !t.retryable(req, resp, err) {
+			t.record(failed)
+			return resp, err
+		}
+		wait := t.backoff(attempt)
+		if resp != nil {
+			if ra, ok := retryAfter(resp.Header.Get("Retry-After")); ok {
+				if ra > t.Policy.MaxBackoff {
+					t.record(failed)
+					return resp, nil
+				}
+				wait = ra
+			}
+		}
+		if req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				t.record(failed)
+				return resp, err
+			}
+			body, berr := req.GetBody()
+			if berr != nil {
+				t.record(failed)
+				return resp, err
+			}
+			req = req.Clone(ctx)
+			req.Body = body
+		}
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+			resp.Body.Close()
+		}
+		slog.Info("retry provider call", "method", req.Method, "uri", req.URL.Redacted(),
+			"attempt", attempt+1, "wait", wait, "err", err)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			t.record(failed)
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// try sends the request with a per attempt timeout, which is released when the body is closed
+func (t *RetryTransport) try(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), t.Policy.Timeout)
+	resp, err := t.Base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+func (t *RetryTransport) retryable(req *http.Request, resp *http.Response, err error) bool {
+	if req.Context().Err() != nil {
+		return false
+	}
+	idempotent := isIdempotent(req)
+	if err != nil {
+		return idempotent
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return idempotent
+	}
+	return false
+}
+
+func (t *RetryTransport) backoff(attempt int) time.Duration {
+	d := t.Policy.MinBackoff << attempt
+	if d <= 0 || d > t.Policy.MaxBackoff {
+		d = t.Policy.MaxBackoff
+	}
+	// equal jitter: half fixed, half random
+	return d/2 + rand.N(d/2+1)
+}
+
+// allow reports whether a call may go, a single trial is allowed after the cooldown of an open circuit
+func (t *RetryTransport) allow() bool {
+	if t.Policy.FailureThreshold < 0 {
+		return true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.failures < t.Policy.FailureThreshold {
+		return true
+	}
+	if t.trialing || time.Since(t.openedAt) < t.Policy.Cooldown {
+		return false
+	}
+	t.trialing = true
+	return true
+}
+
+func (t *RetryTransport) record(failed bool) {
+	if t.Policy.FailureThreshold < 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.trialing = false
+	if !failed {
+		t.failures = 0
+		return
+	}
+	t.failures++
+	if t.failures >= t.Policy.FailureThreshold {
+		if t.failures == t.Policy.FailureThreshold {
+			slog.Warn("provider keeps failing, circuit opened", "failures", t.failures, "cooldown", t.Policy.Cooldown)
+		}
+		t.openedAt = time.Now()
+	}
+}
+
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return len(req.Header.Get("Idempotency-Key")) > 0
+}
+
+// retryAfter parses Retry-After in seconds or an HTTP date
+func retryAfter(s string) (time.Duration, bool) {
+	if len(s) == 0 {
+		return 0, false
+	}
+	if n, err := strconv.Atoi(s); err == nil && n >= 0 {
+		return time.Duration(n) * time.Second, true
+	}
+	if t, err := http.ParseTime(s); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
+
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+func (p *RetryPolicy) wrap(hc *http.Client) *http.Client {
+	cp := *hc
+	cp.Transport = NewRetryTransport(hc.Transport, *p)
+	cp.Timeout = 0
+	return &cp
+}
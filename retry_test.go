@@ -0,0 +1,119 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryTransport(t *testing.T) {
+	fast := RetryPolicy{MaxRetries: 2, MinBackoff: time.Millisecond, MaxBackoff: 50 * time.Millisecond, FailureThreshold: -1}
+	tests := []struct {
+		name   string
+		method string
+		codes  []int // status of each attempt, the last one repeats
+		header string
+		status int
+		hits   int32
+		max    int // MaxRetries, 0 means the one of fast
+	}{
+		{"GET 5xx 后成功", http.MethodGet, []int{502, 500, 200}, "", 200, 3, 0},
+		{"GET 重试耗尽", http.MethodGet, []int{500}, "", 500, 3, 0},
+		{"POST 5xx 不重试", http.MethodPost, []int{500, 200}, "", 500, 1, 0},
+		{"POST 503 重试", http.MethodPost, []int{503, 200}, "", 200, 2, 0},
+		{"429 遵循 Retry-After", http.MethodGet, []int{429, 200}, "0", 200, 2, 0},
+		{"Retry-After 过长不等待", http.MethodGet, []int{429, 200}, "120", 429, 1, 0},
+		{"4xx 不重试", http.MethodGet, []int{404, 200}, "", 404, 1, 0},
+		{"负数禁用重试", http.MethodGet, []int{500, 200}, "", 500, 1, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := int(hits.Add(1))
+				b, _ := io.ReadAll(r.Body)
+				if r.Method == http.MethodPost {
+					assert.Equal(t, "grant_type=x", string(b))
+				}
+				if len(tt.header) > 0 {
+					w.Header().Set("Retry-After", tt.header)
+				}
+				w.WriteHeader(tt.codes[min(n, len(tt.codes))-1])
+			}))
+			defer srv.Close()
+
+			p := fast
+			if tt.max != 0 {
+				p.MaxRetries = tt.max
+			}
+			hc := &http.Client{Transport: NewRetryTransport(nil, p)}
+			var resp *http.Response
+			var err error
+			if tt.method == http.MethodGet {
+				resp, err = hc.Get(srv.URL)
+			} else {
+				resp, err = hc.Post(srv.URL, "application/x-www-form-urlencoded", strings.NewReader("grant_type=x"))
+			}
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, tt.status, resp.StatusCode)
+			assert.Equal(t, tt.hits, hits.Load())
+		})
+	}
+}
+
+func TestRetryTransport_Breaker(t *testing.T) {
+	var hits atomic.Int32
+	var healthy atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	rt := NewRetryTransport(nil, RetryPolicy{MaxRetries: -1, FailureThreshold: 2, Cooldown: 30 * time.Millisecond})
+	hc := &http.Client{Transport: rt}
+	for range 2 {
+		resp, err := hc.Get(srv.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+	_, err := hc.Get(srv.URL)
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.EqualValues(t, 2, hits.Load())
+
+	time.Sleep(40 * time.Millisecond)
+	healthy.Store(true)
+	resp, err := hc.Get(srv.URL)
+	require.NoError(t, err, "trial after cooldown")
+	resp.Body.Close()
+	resp, err = hc.Get(srv.URL)
+	require.NoError(t, err, "closed")
+	resp.Body.Close()
+}
+
+func TestRetryTransport_Context(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	c := New(WithPrefix(srv.URL), WithRetry(RetryPolicy{MaxRetries: 10, MinBackoff: time.Second}))
+	assert.Zero(t, c.HTTPClient().Timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := c.RequestWith(ctx, srv.URL, nil, nil)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+}
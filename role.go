@@ -0,0 +1,429 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+
+	auth "github.com/liut/simpauth"
+)
+
+// RoleWildcard is the pattern of Match which matches any role,
+// a pattern ending with ".*" matches the roles under it, like "ops.*"
+const RoleWildcard = "*"
+
+// ErrInvalidRoleExpr is returned by ParseRoleExpr for a malformed expression
+var ErrInvalidRoleExpr = errors.New("invalid role expression")
+
+// RoleSet is the roles of a user, both the group list (InfoToken.Roles, auth.Names) and
+// the RoleMe map returned by the provider convert into it. It has the underlying type of auth.Names,
+// so the conversions between them are free. Roles are matched exactly, see Match for patterns.
+type RoleSet []string
+
+// NewRoleSet returns a RoleSet of the names in order, empty and duplicate ones are ignored
+func NewRoleSet(names ...string) RoleSet {
+	rs := make(RoleSet, 0, len(names))
+	for _, name := range names {
+		if name = strings.TrimSpace(name); len(name) > 0 && !slices.Contains(rs, name) {
+			rs = append(rs, name)
+		}
+	}
+	return rs
+}
+
+// ParseRoleSet converts the roles returned by the provider, which is a group list ([]string or []any),
+// a RoleMe (map of bool), or a string of names separated by comma or space.
+func ParseRoleSet(v any) (RoleSet, error) {
+	names, err := rolesFromAny(v)
+	if err != nil {
+		return nil, err
+	}
+	return NewRoleSet(names...), nil
+}
+
+func rolesFromAny(v any) ([]string, error) {
+	switch z := v.(type) {
+	case nil:
+		return nil, nil
+	case RoleSet:
+		return z, nil
+	case auth.Names:
+		return z, nil
+	case []string:
+		return z, nil
+	case string:
+		return strings.FieldsFunc(z, func(r rune) bool { return r == ',' || unicode.IsSpace(r) }), nil
+	case RoleMe:
+		return z.RoleSet(), nil
+	case map[string]any:
+		return RoleMe(z).RoleSet(), nil
+	case []any:
+		names := make([]string, 0, len(z))
+		for _, e := range z {
+			s, ok := e.(string)
+			if !ok {
+				return nil, fmt.Errorf("role must be a string, got %T", e)
+			}
+			names = append(names, s)
+		}
+		return names, nil
+	}
+	return nil, fmt.Errorf("roles must be a list or a map, got %T", v)
+}
+
+// Names returns the roles as auth.Names, sorted
+func (rs RoleSet) Names() auth.Names {
+	names := slices.Clone(auth.Names(rs))
+	sort.Strings(names)
+	return names
+}
+
+// Has checks the role is granted, exactly
+func (rs RoleSet) Has(role string) bool {
+	return slices.Contains(rs, role)
+}
+
+// Any checks any of the roles is granted
+func (rs RoleSet) Any(roles ...string) bool {
+	return slices.ContainsFunc(roles, rs.Has)
+}
+
+// All checks all of the roles are granted
+func (rs RoleSet) All(roles ...string) bool {
+	for _, role := range roles {
+		if !rs.Has(role) {
+			return false
+		}
+	}
+	return true
+}
+
+// None checks none of the roles is granted
+func (rs RoleSet) None(roles ...string) bool {
+	return !rs.Any(roles...)
+}
+
+// Match checks any role granted matches the pattern, which is a role, RoleWildcard,
+// or a prefix ending with ".*" like "ops.*" that matches "ops.oncall".
+func (rs RoleSet) Match(pattern string) bool {
+	return slices.ContainsFunc(rs, func(role string) bool { return roleMatch(pattern, role) })
+}
+
+// roleMatch checks the pattern like "*" or "ops.*" matches the role
+func roleMatch(pattern, role string) bool {
+	if pattern == RoleWildcard || pattern == role {
+		return true
+	}
+	if p, ok := strings.CutSuffix(pattern, ".*"); ok {
+		return strings.HasPrefix(role, p+".")
+	}
+	return false
+}
+
+// RoleSet returns the roles which are true as a RoleSet, sorted
+func (r RoleMe) RoleSet() RoleSet {
+	rs := make(RoleSet, 0, len(r))
+	for name := range r {
+		if r.Has(name) {
+			rs = append(rs, name)
+		}
+	}
+	sort.Strings(rs)
+	return rs
+}
+
+// RoleSet returns the roles of the InfoToken as a RoleSet, sharing the slice
+func (it *InfoToken) RoleSet() RoleSet {
+	return RoleSet(it.Roles)
+}
+
+// RoleHierarchy maps a role to the roles it implies, e.g. "admin" implies "editor",
+// the implication is transitive.
+type RoleHierarchy map[string][]string
+
+// WithRoleHierarchy sets the RoleHierarchy, the implied roles are added to the roles of InfoToken
+// received from the provider, so that the signed in user and bearer tokens have them.
+func WithRoleHierarchy(h RoleHierarchy) Option {
+	return func(c *Client) {
+		c.roleHierarchy = h
+	}
+}
+
+// Expand returns the names with the roles implied, in the order of names and then the implied
+func (h RoleHierarchy) Expand(names auth.Names) auth.Names {
+	if len(h) == 0 {
+		return names
+	}
+	out := slices.Clone(names)
+	for i := 0; i < len(out); i++ {
+		for _, implied := range h[out[i]] {
+			if !slices.Contains(out, implied) {
+				out = append(out, implied)
+			}
+		}
+	}
+	return out
+}
+
+// WithRequiredRoles sets the role expression required in the auth-code callback,
+// it is checked besides the roles given to AuthRequestWithRole.
+func WithRequiredRoles(e *RoleExpr) Option {
+	return func(c *Client) {
+		c.requiredRoles = e
+	}
+}
+
+// requestRoles returns the roles to filter the info of the provider, with the roles implying them
+// and the roles of the required expression, none if any role is a wildcard.
+func (c *Client) requestRoles(roles []string) []string {
+	if len(roles) == 0 {
+		return nil
+	}
+	out := slices.Clone(roles)
+	if c.requiredRoles != nil {
+		for _, rn := range c.requiredRoles.Roles() {
+			if !slices.Contains(out, rn) {
+				out = append(out, rn)
+			}
+		}
+	}
+	for i := 0; i < len(out); i++ {
+		if strings.Contains(out[i], RoleWildcard) {
+			return nil
+		}
+		var parents []string
+		for parent, implied := range c.roleHierarchy {
+			if slices.Contains(implied, out[i]) && !slices.Contains(out, parent) {
+				parents = append(parents, parent)
+			}
+		}
+		sort.Strings(parents)
+		out = append(out, parents...)
+	}
+	return out
+}
+
+// expandRoles adds the roles implied by the hierarchy of the client to the InfoToken
+func (c *Client) expandRoles(it *InfoToken) {
+	if it != nil && len(c.roleHierarchy) > 0 {
+		it.Roles = c.roleHierarchy.Expand(it.Roles)
+	}
+}
+
+// RoleExpr is a boolean expression of roles, like `admin || (ops && oncall) && !intern`,
+// the operators are `!`, `&&` and `||` in precedence order, and `and`, `or`, `not` are aliases.
+// A name with "*" is a pattern checked by RoleSet.Match, others are matched exactly.
+// It can be decoded from the text of a config file. The zero value allows nothing.
+type RoleExpr struct {
+	root roleNode
+	src  string
+}
+
+// ParseRoleExpr parses a role expression, roles may be patterns like "ops.*"
+func ParseRoleExpr(s string) (*RoleExpr, error) {
+	p := &roleParser{toks: lexRoles(s)}
+	if len(p.toks) == 0 {
+		return nil, fmt.Errorf("%w: empty", ErrInvalidRoleExpr)
+	}
+	root, err := p.parseOr()
+	if err == nil && p.pos < len(p.toks) {
+		err = fmt.Errorf("%w: unexpected %q", ErrInvalidRoleExpr, p.toks[p.pos])
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &RoleExpr{root: root, src: strings.TrimSpace(s)}, nil
+}
+
+// MustParseRoleExpr is like ParseRoleExpr but panics if the expression is malformed
+func MustParseRoleExpr(s string) *RoleExpr {
+	e, err := ParseRoleExpr(s)
+	if err != nil {
+		panic(err)
+	}
+	return e
+}
+
+// Eval checks the roles satisfy the expression, false for a nil or zero expression
+func (e *RoleExpr) Eval(rs RoleSet) bool {
+	if e == nil || e.root == nil {
+		return false
+	}
+	return e.root.eval(rs)
+}
+
+// Roles returns the roles referred by the expression
+func (e *RoleExpr) Roles() []string {
+	var roles []string
+	if e != nil && e.root != nil {
+		e.root.collect(&roles)
+	}
+	return roles
+}
+
+func (e *RoleExpr) String() string {
+	if e == nil {
+		return ""
+	}
+	return e.src
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (e *RoleExpr) MarshalText() ([]byte, error) {
+	return []byte(e.src), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (e *RoleExpr) UnmarshalText(b []byte) error {
+	pe, err := ParseRoleExpr(string(b))
+	if err != nil {
+		return err
+	}
+	*e = *pe
+	return nil
+}
+
+type roleNode interface {
+	eval(rs RoleSet) bool
+	collect(roles *[]string)
+}
+
+type roleName string
+
+func (n roleName) eval(rs RoleSet) bool {
+	if strings.Contains(string(n), RoleWildcard) {
+		return rs.Match(string(n))
+	}
+	return rs.Has(string(n))
+}
+func (n roleName) collect(roles *[]string) {
+	if !slices.Contains(*roles, string(n)) {
+		*roles = append(*roles, string(n))
+	}
+}
+
+type roleNot struct{ x roleNode }
+
+func (n roleNot) eval(rs RoleSet) bool    { return !n.x.eval(rs) }
+func (n roleNot) collect(roles *[]string) { n.x.collect(roles) }
+
+type roleBinary struct {
+	and  bool
+	x, y roleNode
+}
+
+func (n roleBinary) eval(rs RoleSet) bool {
+	if n.and {
+		return n.x.eval(rs) && n.y.eval(rs)
+	}
+	return n.x.eval(rs) || n.y.eval(rs)
+}
+func (n roleBinary) collect(roles *[]string) {
+	n.x.collect(roles)
+	n.y.collect(roles)
+}
+
+// lexRoles splits the expression into operators, parentheses and role names
+func lexRoles(s string) (toks []string) {
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(' || c == ')' || c == '!':
+			toks = append(toks, s[i:i+1])
+			i++
+		case (c == '&' || c == '|') && i+1 < len(s) && s[i+1] == c:
+			toks = append(toks, s[i:i+2])
+			i += 2
+		default:
+			j := i
+			for j < len(s) && !strings.ContainsRune(" \t\r\n()!&|", rune(s[j])) {
+				j++
+			}
+			if j == i { // a single & or |
+				j++
+			}
+			tok := s[i:j]
+			switch strings.ToLower(tok) {
+			case "and":
+				tok = "&&"
+			case "or":
+				tok = "||"
+			case "not":
+				tok = "!"
+			}
+			toks = append(toks, tok)
+			i = j
+		}
+	}
+	return
+}
+
+type roleParser struct {
+	toks []string
+	pos  int
+}
+
+func (p *roleParser) peek() string {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return ""
+}
+
+func (p *roleParser) parseOr() (roleNode, error) {
+	x, err := p.parseAnd()
+	for err == nil && p.peek() == "||" {
+		p.pos++
+		var y roleNode
+		if y, err = p.parseAnd(); err == nil {
+			x = roleBinary{x: x, y: y}
+		}
+	}
+	return x, err
+}
+
+func (p *roleParser) parseAnd() (roleNode, error) {
+	x, err := p.parseUnary()
+	for err == nil && p.peek() == "&&" {
+		p.pos++
+		var y roleNode
+		if y, err = p.parseUnary(); err == nil {
+			x = roleBinary{and: true, x: x, y: y}
+		}
+	}
+	return x, err
+}
+
+func (p *roleParser) parseUnary() (roleNode, error) {
+	tok := p.peek()
+	switch tok {
+	case "":
+		return nil, fmt.Errorf("%w: unexpected end", ErrInvalidRoleExpr)
+	case "!":
+		p.pos++
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return roleNot{x}, nil
+	case "(":
+		p.pos++
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("%w: missing )", ErrInvalidRoleExpr)
+		}
+		p.pos++
+		return x, nil
+	case ")", "&&", "||", "&", "|":
+		return nil, fmt.Errorf("%w: unexpected %q", ErrInvalidRoleExpr, tok)
+	}
+	p.pos++
+	return roleName(tok), nil
+}
@@ -0,0 +1,232 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	auth "github.com/liut/simpauth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoleSet(t *testing.T) {
+	rs := NewRoleSet("staff", "ops.db", " ", "editor", "staff")
+	tests := []struct {
+		name string
+		got  bool
+		want bool
+	}{
+		{"直接拥有", rs.Has("staff"), true},
+		{"未拥有", rs.Has("admin"), false},
+		{"查询通配不生效", rs.Has("ops.*"), false},
+		{"授予通配不生效", NewRoleSet("*").Has("anything"), false},
+		{"显式匹配通配", rs.Match("ops.*"), true},
+		{"匹配不含父级", NewRoleSet("ops").Match("ops.*"), false},
+		{"匹配全部", rs.Match(RoleWildcard), true},
+		{"空集不匹配", RoleSet(nil).Match(RoleWildcard), false},
+		{"任一", rs.Any("admin", "editor"), true},
+		{"任一未命中", rs.Any("admin", "root"), false},
+		{"全部", rs.All("staff", "ops.db"), true},
+		{"全部缺一", rs.All("staff", "admin"), false},
+		{"全无", rs.None("admin", "root"), true},
+		{"全无命中", rs.None("admin", "staff"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.got)
+		})
+	}
+	assert.Equal(t, RoleSet{"staff", "ops.db", "editor"}, rs, "deduplicated in order")
+	assert.Equal(t, auth.Names{"editor", "ops.db", "staff"}, rs.Names())
+
+	it := &InfoToken{Roles: auth.Names{"admin"}}
+	assert.True(t, it.HasRole("admin"))
+	assert.False(t, it.HasRole(RoleWildcard), "exact")
+}
+
+func TestParseRoleSet(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      any
+		want    auth.Names
+		wantErr bool
+	}{
+		{"组列表", []any{"staff", "admin"}, auth.Names{"admin", "staff"}, false},
+		{"字符串列表", []string{"staff"}, auth.Names{"staff"}, false},
+		{"RoleMe", map[string]any{"admin": true, "intern": false, "ops": "yes"}, auth.Names{"admin"}, false},
+		{"逗号分隔", "admin, ops staff", auth.Names{"admin", "ops", "staff"}, false},
+		{"空", nil, auth.Names{}, false},
+		{"非字符串元素", []any{"staff", 1}, nil, true},
+		{"未知类型", 42, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs, err := ParseRoleSet(tt.in)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, rs.Names())
+		})
+	}
+	assert.Equal(t, RoleSet{"admin", "ops"}, RoleMe{"ops": true, "admin": true, "dev": false}.RoleSet())
+}
+
+func TestRoleExpr(t *testing.T) {
+	tests := []struct {
+		name  string
+		expr  string
+		roles []string
+		want  bool
+	}{
+		{"或", "admin || (ops && oncall)", []string{"admin"}, true},
+		{"括号内与", "admin || (ops && oncall)", []string{"ops", "oncall"}, true},
+		{"括号内缺一", "admin || (ops && oncall)", []string{"ops"}, false},
+		{"与优先于或", "admin || ops && oncall", []string{"oncall"}, false},
+		{"非", "staff && !intern", []string{"staff", "intern"}, false},
+		{"双重非", "!!staff", []string{"staff"}, true},
+		{"单词运算符", "staff and not (intern or contractor)", []string{"staff"}, true},
+		{"通配", "ops.* && !ops.readonly", []string{"ops.db"}, true},
+		{"通配未命中", "ops.* && !ops.readonly", []string{"ops.db", "ops.readonly"}, false},
+		{"授予通配不生效", "admin", []string{"*"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := ParseRoleExpr(tt.expr)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, e.Eval(NewRoleSet(tt.roles...)))
+		})
+	}
+
+	for _, s := range []string{"", "admin ||", "(admin", "admin)", "&& ops", "admin ops", "admin & ops"} {
+		_, err := ParseRoleExpr(s)
+		assert.True(t, errors.Is(err, ErrInvalidRoleExpr), s)
+	}
+	assert.Panics(t, func() { MustParseRoleExpr("(") })
+
+	e := MustParseRoleExpr("admin || (ops && oncall) || admin")
+	assert.Equal(t, []string{"admin", "ops", "oncall"}, e.Roles())
+
+	var zero RoleExpr
+	assert.False(t, zero.Eval(NewRoleSet("admin")), "zero value allows nothing")
+	assert.Empty(t, zero.Roles())
+	assert.False(t, (*RoleExpr)(nil).Eval(NewRoleSet("admin")))
+	assert.Panics(t, func() { RoleExprPolicy(nil) })
+	assert.Panics(t, func() { RoleExprPolicy(&zero) })
+
+	var cfg struct {
+		Require *RoleExpr `json:"require"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(`{"require":"admin || ops"}`), &cfg))
+	assert.True(t, cfg.Require.Eval(NewRoleSet("ops")))
+	assert.Error(t, json.Unmarshal([]byte(`{"require":"admin ||"}`), &cfg))
+}
+
+func TestRoleHierarchy(t *testing.T) {
+	h := RoleHierarchy{"admin": {"editor", "ops"}, "editor": {"viewer"}, "ops": {"viewer"}}
+	tests := []struct {
+		name  string
+		names auth.Names
+		want  auth.Names
+	}{
+		{"传递展开", auth.Names{"admin"}, auth.Names{"admin", "editor", "ops", "viewer"}},
+		{"无下级", auth.Names{"viewer"}, auth.Names{"viewer"}},
+		{"已拥有不重复", auth.Names{"editor", "viewer", "staff"}, auth.Names{"editor", "viewer", "staff"}},
+		{"空", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, h.Expand(tt.names))
+		})
+	}
+
+	c := New(WithRoleHierarchy(h), WithRequiredRoles(MustParseRoleExpr("staff && !intern")))
+	assert.Equal(t, []string{"viewer", "staff", "intern", "editor", "ops", "admin"}, c.requestRoles([]string{"viewer"}))
+	assert.Nil(t, c.requestRoles(nil))
+	assert.Nil(t, c.requestRoles([]string{"ops.*"}))
+}
+
+func TestInfoToken_GroupFormats(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want auth.Names
+	}{
+		{"组列表", `{"access_token":"at","group":["staff","admin"]}`, auth.Names{"staff", "admin"}},
+		{"RoleMe", `{"access_token":"at","group":{"admin":true,"intern":false}}`, auth.Names{"admin"}},
+		{"无组", `{"access_token":"at"}`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var it InfoToken
+			require.NoError(t, json.Unmarshal([]byte(tt.body), &it))
+			assert.Equal(t, "at", it.AccessToken)
+			assert.Equal(t, tt.want, it.Roles)
+		})
+	}
+	var it InfoToken
+	assert.Error(t, json.Unmarshal([]byte(`{"group":[1]}`), &it))
+}
+
+func TestClient_AuthRequestWithRoleExpr(t *testing.T) {
+	var mu sync.Mutex
+	var infoPaths []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"access_token": "at", "token_type": "Bearer", "expires_in": 3600})
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		infoPaths = append(infoPaths, r.URL.Path)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "at", "me": map[string]string{"uid": "alice"},
+			"group": map[string]bool{"admin": true, "intern": false},
+		})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	login := func(c *Client, roles ...string) int {
+		rec := httptest.NewRecorder()
+		authURL := c.LoginStart(rec, httptest.NewRequest(http.MethodGet, "/auth/login", nil))
+		u, _ := url.Parse(authURL)
+		r := requestWithCookies(rec)
+		r.URL.RawQuery = url.Values{"state": {u.Query().Get("state")}, "code": {"c1"}}.Encode()
+		rec = httptest.NewRecorder()
+		c.AuthCodeCallback(roles...).ServeHTTP(rec, r)
+		return rec.Code
+	}
+	opts := []Option{WithPrefix(srv.URL), WithClientID("cid", "secret"), WithRoleHierarchy(RoleHierarchy{"admin": {"editor"}})}
+	tests := []struct {
+		name  string
+		expr  string
+		roles []string
+		path  string
+		want  int
+	}{
+		{"上级角色隐含", "", []string{"editor"}, "/info/me|editor|admin", http.StatusFound},
+		{"表达式满足", "editor && !intern", nil, "/info/me", http.StatusFound},
+		{"表达式不满足", "ops || oncall", nil, "/info/me", http.StatusForbidden},
+		{"表达式角色一并请求", "admin || ops", []string{"editor"}, "/info/me|editor|admin|ops", http.StatusFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := opts
+			if len(tt.expr) > 0 {
+				o = append(o[:len(o):len(o)], WithRequiredRoles(MustParseRoleExpr(tt.expr)))
+			}
+			infoPaths = nil
+			assert.Equal(t, tt.want, login(New(o...), tt.roles...))
+			assert.Equal(t, []string{tt.path}, infoPaths)
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// ServiceEarlyExpiry is how early a client credentials token is refreshed before it expires
+var ServiceEarlyExpiry = time.Minute
+
+// serviceSources caches client credentials token sources by scopes
+type serviceSources struct {
+	mu      sync.Mutex
+	sources map[string]oauth2.TokenSource
+}
+
+// ClientCredentials returns the client credentials config built from the client
+func (c *Client) ClientCredentials(scopes ...string) *clientcredentials.Config {
+	return &clientcredentials.Config{
+		ClientID:     c.conf.ClientID,
+		ClientSecret: c.conf.ClientSecret,
+		TokenURL:     c.conf.Endpoint.TokenURL,
+		Scopes:       scopes,
+		AuthStyle:    c.conf.Endpoint.AuthStyle,
+	}
+}
+
+// ServiceTokenSource returns a cached TokenSource of the default client for service-to-service calls
+func ServiceTokenSource(scopes ...string) oauth2.TokenSource {
+	return Default().ServiceTokenSource(scopes...)
+}
+
+// ServiceTokenSource returns a TokenSource with the client credentials grant, which caches the token
+// and refreshes it ServiceEarlyExpiry before expiry. Sources are shared by the same scopes.
+func (c *Client) ServiceTokenSource(scopes ...string) oauth2.TokenSource {
+	scopes = slices.Clone(scopes)
+	slices.Sort(scopes)
+	key := strings.Join(scopes, " ")
+
+	c.services.mu.Lock()
+	defer c.services.mu.Unlock()
+	if ts, ok := c.services.sources[key]; ok {
+		return ts
+	}
+	if c.services.sources == nil {
+		c.services.sources = make(map[string]oauth2.TokenSource)
+	}
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, c.httpClient)
+	ts := oauth2.ReuseTokenSourceWithExpiry(nil, c.ClientCredentials(scopes...).TokenSource(ctx), ServiceEarlyExpiry)
+	c.services.sources[key] = ts
+	return ts
+}
+
+// ServiceHTTPClient returns an http.Client of the default client which authenticates as the application
+func ServiceHTTPClient(scopes ...string) *http.Client {
+	return Default().ServiceHTTPClient(scopes...)
+}
+
+// ServiceHTTPClient returns an http.Client which authenticates as the application automatically
+func (c *Client) ServiceHTTPClient(scopes ...string) *http.Client {
+	return &http.Client{
+		Timeout: c.httpClient.Timeout,
+		Transport: &oauth2.Transport{
+			Source: c.ServiceTokenSource(scopes...),
+			Base:   c.httpClient.Transport,
+		},
+	}
+}
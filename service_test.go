@@ -0,0 +1,61 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_ServiceTokenSource(t *testing.T) {
+	var hits atomic.Int32
+	expiresIn := 3600
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		assert.Equal(t, "client_credentials", r.PostForm.Get("grant_type"))
+		n := hits.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token": fmt.Sprintf("svc%d", n), "token_type": "Bearer", "expires_in": expiresIn,
+		})
+	})
+	mux.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Header.Get("Authorization")))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	c := New(WithPrefix(srv.URL), WithClientID("cid", "secret"))
+	ts := c.ServiceTokenSource("staff", "read")
+	assert.Same(t, ts, c.ServiceTokenSource("read", "staff"))
+
+	tok, err := ts.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "svc1", tok.AccessToken)
+	tok, err = ts.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "svc1", tok.AccessToken, "cached")
+	assert.EqualValues(t, 1, hits.Load())
+
+	// a token expires within ServiceEarlyExpiry is refreshed
+	expiresIn = 30
+	tok, err = c.ServiceTokenSource("other").Token()
+	require.NoError(t, err)
+	assert.Equal(t, "svc2", tok.AccessToken)
+	tok, err = c.ServiceTokenSource("other").Token()
+	require.NoError(t, err)
+	assert.Equal(t, "svc3", tok.AccessToken)
+
+	resp, err := c.ServiceHTTPClient("staff", "read").Get(srv.URL + "/api")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var buf [64]byte
+	n, _ := resp.Body.Read(buf[:])
+	assert.Equal(t, "Bearer svc1", string(buf[:n]))
+}
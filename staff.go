@@ -46,6 +46,7 @@ func (s Staff) ToO2User() (ou O2User) {
 	return
 }
 
+// RoleMe is the roles in the map format of the provider, check them with RoleMe.RoleSet
 type RoleMe map[string]any
 
 func (r RoleMe) Has(name string) bool {
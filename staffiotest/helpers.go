@@ -0,0 +1,76 @@
+package staffiotest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	staffio "github.com/liut/staffio-client"
+)
+
+// Login drives a full login: LoginStart of c (as LoginHandler does), /authorize of the provider,
+// and the callback handler, returns the response of the callback.
+func Login(t testing.TB, c *staffio.Client, callback http.Handler) *httptest.ResponseRecorder {
+	t.Helper()
+	return LoginRequest(t, c, httptest.NewRequest(http.MethodGet, "/auth/login", nil), callback)
+}
+
+// LoginRequest is Login with a custom login request, e.g. with return_to
+func LoginRequest(t testing.TB, c *staffio.Client, r *http.Request, callback http.Handler) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	authURL := c.LoginStart(rec, r)
+
+	hc := *c.HTTPClient()
+	hc.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	resp, err := hc.Get(authURL)
+	if err != nil {
+		t.Fatalf("authorize: %s", err)
+	}
+	resp.Body.Close()
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if resp.StatusCode != http.StatusFound || err != nil {
+		t.Fatalf("authorize: want redirect, got %s", resp.Status)
+	}
+
+	cr := httptest.NewRequest(http.MethodGet, loc.RequestURI(), nil)
+	cr.Host = loc.Host
+	for _, ck := range Cookies(rec) {
+		cr.AddCookie(ck)
+	}
+	out := httptest.NewRecorder()
+	callback.ServeHTTP(out, cr)
+	return out
+}
+
+// Cookies returns the cookies set by the response, without the deleted ones
+func Cookies(rec *httptest.ResponseRecorder) (cookies []*http.Cookie) {
+	for _, ck := range rec.Result().Cookies() {
+		if ck.MaxAge >= 0 {
+			cookies = append(cookies, ck)
+		}
+	}
+	return
+}
+
+// SignedInCookies returns the session cookies of the user signed in by c, without the provider
+func SignedInCookies(t testing.TB, c *staffio.Client, u User) []*http.Cookie {
+	t.Helper()
+	user := &staffio.User{UID: u.UID, Name: u.Name, Roles: u.Roles}
+	user.Refresh()
+	rec := httptest.NewRecorder()
+	if err := c.Signin(user, rec); err != nil {
+		t.Fatalf("signin: %s", err)
+	}
+	return Cookies(rec)
+}
+
+// SignIn adds the session cookies of the user to the request, for testing protected routes
+func SignIn(t testing.TB, c *staffio.Client, r *http.Request, u User) *http.Request {
+	t.Helper()
+	for _, ck := range SignedInCookies(t, c, u) {
+		r.AddCookie(ck)
+	}
+	return r
+}
@@ -0,0 +1,343 @@
+// Package staffiotest provides an in-process fake Staffio provider and helpers
+// for testing apps with staffio-client.
+package staffiotest
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	staffio "github.com/liut/staffio-client"
+	"golang.org/x/oauth2"
+)
+
+// default credentials of the fake client
+const (
+	ClientID     = "test-client"
+	ClientSecret = "test-secret"
+)
+
+// User is a user of the fake provider
+type User struct {
+	UID   string
+	Name  string
+	Email string
+	Roles []string
+	Meta  map[string]any
+}
+
+// Staff returns the user as staffio.Staff
+func (u User) Staff() *staffio.Staff {
+	return &staffio.Staff{UID: u.UID, CommonName: u.Name, Nickname: u.Name, Email: u.Email}
+}
+
+type grant struct {
+	uid         string
+	redirectURI string
+	challenge   string
+	expires     time.Time
+}
+
+type injected struct {
+	status int
+	code   string
+}
+
+// Provider is a fake Staffio provider on httptest.Server, implements /authorize, /token and /info/me.
+// The authorize endpoint approves the login user at once, without any page.
+type Provider struct {
+	*httptest.Server
+
+	// TokenTTL is the lifetime of access tokens, default 1 hour
+	TokenTTL time.Duration
+
+	mu       sync.Mutex
+	users    map[string]User
+	loginUID string
+	codes    map[string]grant
+	access   map[string]accessToken
+	refresh  map[string]string // refresh token -> uid
+	faults   map[string][]injected
+}
+
+type accessToken struct {
+	uid     string
+	expires time.Time
+}
+
+// NewProvider starts a fake provider with users, the first one is the login user.
+// It is closed on the cleanup of t.
+func NewProvider(t testing.TB, users ...User) *Provider {
+	p := &Provider{
+		TokenTTL: time.Hour,
+		users:    make(map[string]User),
+		codes:    make(map[string]grant),
+		access:   make(map[string]accessToken),
+		refresh:  make(map[string]string),
+		faults:   make(map[string][]injected),
+	}
+	for _, u := range users {
+		p.AddUser(u)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/authorize", p.handleAuthorize)
+	mux.HandleFunc("/token", p.handleToken)
+	mux.HandleFunc("/info/", p.handleInfo)
+	p.Server = httptest.NewServer(p.faulty(mux))
+	t.Cleanup(p.Close)
+	return p
+}
+
+// AddUser adds or replaces a user, the first one added is the login user
+func (p *Provider) AddUser(u User) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.users[u.UID] = u
+	if len(p.loginUID) == 0 {
+		p.loginUID = u.UID
+	}
+}
+
+// SetLoginUser sets the user approved by /authorize, empty means the user denies with access_denied
+func (p *Provider) SetLoginUser(uid string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.loginUID = uid
+}
+
+// FailNext makes the next request to the path ("/authorize", "/token" or "/info/me")
+// fail with the status and the OAuth2 error code, calls are queued.
+func (p *Provider) FailNext(path string, status int, code string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.faults[path] = append(p.faults[path], injected{status: status, code: code})
+}
+
+// Client returns a client of the provider with the default credentials, opts are applied after them.
+// The client authenticates in the header, so that an injected fault of /token is not retried in params.
+func (p *Provider) Client(opts ...staffio.Option) *staffio.Client {
+	c := staffio.New(append([]staffio.Option{
+		staffio.WithPrefix(p.URL),
+		staffio.WithClientID(ClientID, ClientSecret),
+		staffio.WithHTTPClient(p.Server.Client()),
+	}, opts...)...)
+	c.Config().Endpoint.AuthStyle = oauth2.AuthStyleInHeader
+	return c
+}
+
+// IssueToken issues a token of the user, for testing APIs with bearer tokens
+func (p *Provider) IssueToken(uid string) *oauth2.Token {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.issue(uid)
+}
+
+// RevokeTokens invalidates all tokens of the user
+func (p *Provider) RevokeTokens(uid string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for k, at := range p.access {
+		if at.uid == uid {
+			delete(p.access, k)
+		}
+	}
+	for k, v := range p.refresh {
+		if v == uid {
+			delete(p.refresh, k)
+		}
+	}
+}
+
+func (p *Provider) issue(uid string) *oauth2.Token {
+	tok := &oauth2.Token{
+		AccessToken:  randString(),
+		TokenType:    "Bearer",
+		RefreshToken: randString(),
+		Expiry:       time.Now().Add(p.TokenTTL),
+	}
+	p.access[tok.AccessToken] = accessToken{uid: uid, expires: tok.Expiry}
+	p.refresh[tok.RefreshToken] = uid
+	return tok.WithExtra(map[string]any{"uid": uid})
+}
+
+func (p *Provider) faulty(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		if strings.HasPrefix(path, "/info/") {
+			path, _, _ = strings.Cut(path, "|")
+		}
+		p.mu.Lock()
+		var f *injected
+		if q := p.faults[path]; len(q) > 0 {
+			f, p.faults[path] = &q[0], q[1:]
+		}
+		p.mu.Unlock()
+		if f != nil {
+			writeError(w, f.status, f.code, "injected")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (p *Provider) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	redirectURI, state := q.Get("redirect_uri"), q.Get("state")
+	if q.Get("client_id") != ClientID || q.Get("response_type") != "code" || len(redirectURI) == 0 {
+		writeError(w, http.StatusBadRequest, "invalid_request", "bad client_id, response_type or redirect_uri")
+		return
+	}
+	if m := q.Get("code_challenge_method"); len(m) > 0 && m != "S256" {
+		writeError(w, http.StatusBadRequest, "invalid_request", "unsupported code_challenge_method")
+		return
+	}
+	ret := url.Values{"state": {state}}
+	p.mu.Lock()
+	uid := p.loginUID
+	if len(uid) == 0 {
+		ret.Set("error", "access_denied")
+		ret.Set("error_description", "the user denied")
+	} else {
+		code := randString()
+		p.codes[code] = grant{uid: uid, redirectURI: redirectURI, challenge: q.Get("code_challenge"),
+			expires: time.Now().Add(time.Minute)}
+		ret.Set("code", code)
+	}
+	p.mu.Unlock()
+	sep := "?"
+	if strings.Contains(redirectURI, "?") {
+		sep = "&"
+	}
+	http.Redirect(w, r, redirectURI+sep+ret.Encode(), http.StatusFound)
+}
+
+func (p *Provider) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "invalid_request", "POST required")
+		return
+	}
+	_ = r.ParseForm()
+	id, secret, ok := r.BasicAuth()
+	if ok {
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if id != ClientID || secret != ClientSecret {
+		writeError(w, http.StatusUnauthorized, "invalid_client", "bad client credentials")
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var uid string
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		code := r.PostForm.Get("code")
+		g, ok := p.codes[code]
+		delete(p.codes, code)
+		if !ok || time.Now().After(g.expires) {
+			writeError(w, http.StatusBadRequest, "invalid_grant", "the code is invalid or expired")
+			return
+		}
+		if ru := r.PostForm.Get("redirect_uri"); len(ru) > 0 && ru != g.redirectURI {
+			writeError(w, http.StatusBadRequest, "invalid_grant", "redirect_uri mismatch")
+			return
+		}
+		if len(g.challenge) > 0 && s256(r.PostForm.Get("code_verifier")) != g.challenge {
+			writeError(w, http.StatusBadRequest, "invalid_grant", "code_verifier mismatch")
+			return
+		}
+		uid = g.uid
+	case "refresh_token":
+		rt := r.PostForm.Get("refresh_token")
+		if uid, ok = p.refresh[rt]; !ok {
+			writeError(w, http.StatusBadRequest, "invalid_grant", "the refresh token is invalid")
+			return
+		}
+		delete(p.refresh, rt) // rotated
+	case "client_credentials":
+		uid = ""
+	default:
+		writeError(w, http.StatusBadRequest, "unsupported_grant_type", "")
+		return
+	}
+	tok := p.issue(uid)
+	out := map[string]any{
+		"access_token": tok.AccessToken,
+		"token_type":   tok.TokenType,
+		"expires_in":   int64(p.TokenTTL.Seconds()),
+	}
+	if len(uid) > 0 {
+		out["refresh_token"] = tok.RefreshToken
+		out["uid"] = uid
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// handleInfo serves /info/me, the optional "|role" parts of the path limit the roles returned
+func (p *Provider) handleInfo(w http.ResponseWriter, r *http.Request) {
+	path, parts, _ := strings.Cut(r.URL.Path, "|")
+	if path != "/info/me" {
+		http.NotFound(w, r)
+		return
+	}
+	token := staffio.BearerToken(r)
+	p.mu.Lock()
+	at, ok := p.access[token]
+	user, found := p.users[at.uid]
+	p.mu.Unlock()
+	if !ok || time.Now().After(at.expires) || !found {
+		writeError(w, http.StatusUnauthorized, "invalid_token", "the access token is invalid")
+		return
+	}
+	roles := user.Roles
+	if len(parts) > 0 {
+		roles = nil
+		for _, rn := range strings.Split(parts, "|") {
+			if slices.Contains(user.Roles, rn) {
+				roles = append(roles, rn)
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, staffio.InfoToken{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(time.Until(at.expires).Seconds()),
+		Me:          user.Staff(),
+		Roles:       roles,
+		Meta:        user.Meta,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, desc string) {
+	writeJSON(w, status, staffio.InfoError{ErrCode: code, ErrMessage: desc})
+}
+
+func randString() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func s256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,113 @@
+package staffiotest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	staffio "github.com/liut/staffio-client"
+)
+
+func TestProvider_Login(t *testing.T) {
+	p := NewProvider(t,
+		User{UID: "alice", Name: "Alice", Roles: []string{"staff", "admin"}, Meta: map[string]any{"dept": "dev"}},
+		User{UID: "bob", Name: "Bob", Roles: []string{"staff"}})
+
+	tests := []struct {
+		name   string
+		login  string
+		roles  []string
+		fault  string
+		status int
+	}{
+		{"登录成功", "alice", []string{"admin"}, "", http.StatusFound},
+		{"缺少角色", "bob", []string{"admin"}, "", http.StatusForbidden},
+		{"用户拒绝", "", nil, "", http.StatusForbidden},
+		{"令牌接口故障", "alice", nil, "/token", http.StatusBadRequest},
+		{"信息接口故障", "alice", nil, "/info/me", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p.SetLoginUser(tt.login)
+			switch tt.fault {
+			case "/token":
+				p.FailNext(tt.fault, http.StatusBadRequest, "invalid_grant")
+			case "/info/me":
+				p.FailNext(tt.fault, http.StatusUnauthorized, "invalid_token")
+			}
+			c := p.Client(staffio.WithTokenStore(staffio.NewMemoryTokenStore()))
+			rec := Login(t, c, c.AuthCodeCallback(tt.roles...))
+			assert.Equal(t, tt.status, rec.Code, rec.Body.String())
+			if tt.status != http.StatusFound {
+				return
+			}
+			assert.Equal(t, staffio.AdminPath, rec.Header().Get("Location"))
+
+			// the session cookie works with the middleware
+			r := httptest.NewRequest(http.MethodGet, "/admin/", nil)
+			for _, ck := range Cookies(rec) {
+				r.AddCookie(ck)
+			}
+			user, err := c.UserFromRequest(r)
+			require.NoError(t, err)
+			assert.Equal(t, "alice", user.UID)
+
+			// the stored token is refreshed and rotated
+			p.TokenTTL = -time.Minute
+			defer func() { p.TokenTTL = time.Hour }()
+			ctx := context.Background()
+			old, err := c.TokenStore().Get(ctx, "alice")
+			require.NoError(t, err)
+			old.Expiry = time.Now().Add(-time.Second)
+			require.NoError(t, c.TokenStore().Put(ctx, "alice", old))
+			ts, err := c.TokenSourceForUser(ctx, "alice")
+			require.NoError(t, err)
+			tok, err := ts.Token()
+			require.NoError(t, err)
+			assert.NotEqual(t, old.AccessToken, tok.AccessToken)
+		})
+	}
+}
+
+func TestProvider_Info(t *testing.T) {
+	p := NewProvider(t, User{UID: "alice", Roles: []string{"staff", "admin"}, Meta: map[string]any{"dept": "dev"}})
+	c := p.Client()
+	tok := p.IssueToken("alice")
+	ctx := context.Background()
+
+	it, err := c.RequestInfoToken(ctx, tok)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"staff", "admin"}, it.Roles)
+	assert.Equal(t, "dev", it.Meta.GetStr("dept"))
+
+	it, err = c.RequestInfoToken(ctx, tok, "admin", "ops")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"admin"}, []string(it.Roles), "only requested roles")
+
+	p.RevokeTokens("alice")
+	_, err = c.RequestInfoToken(ctx, tok)
+	assert.ErrorIs(t, err, &staffio.OAuthError{Code: "invalid_token"})
+}
+
+func TestSignIn(t *testing.T) {
+	p := NewProvider(t)
+	c := p.Client()
+	h := c.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _ := staffio.UserFromContext(r.Context())
+		_, _ = w.Write([]byte(user.GetUID()))
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/", nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, SignIn(t, c, httptest.NewRequest(http.MethodGet, "/admin/", nil), User{UID: "carol"}))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "carol", rec.Body.String())
+}
@@ -1,27 +1,69 @@
 package client
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
 	"log/slog"
 	"net/http"
+	"time"
+
+	"golang.org/x/oauth2"
 )
 
 const (
-	cKeyState = "staffio_state"
+	cKeyState     = "staffio_state"
+	cKeyStateData = "staffio_state_data"
 )
 
+// StateTTL is the lifetime of a pending state
+var StateTTL = 10 * time.Minute
+
 type StateStore interface {
 	Save(w http.ResponseWriter, state string) error
 	Verify(r *http.Request, state string) bool
 	Wipe(w http.ResponseWriter, state string)
 }
 
+// StateData is the payload kept alongside a pending state
+type StateData struct {
+	State    string `json:"s"`
+	Verifier string `json:"v,omitempty"` // PKCE code_verifier
+	Nonce    string `json:"n,omitempty"` // OIDC nonce
+	ReturnTo string `json:"r,omitempty"` // the originally requested URL
+	Expires  int64  `json:"e,omitempty"` // unix time
+}
+
+// IsExpired checks the expiry of the state
+func (sd StateData) IsExpired() bool {
+	return sd.Expires > 0 && time.Now().Unix() > sd.Expires
+}
+
+// StateDataStore is an optional interface of StateStore, which keeps StateData with the state.
+// When the registered StateStore implements it, the client uses it instead of the StateStore methods,
+// and PKCE, nonce and return URL are enabled only in this case.
+type StateDataStore interface {
+	SaveData(w http.ResponseWriter, r *http.Request, data StateData) error
+	LoadData(r *http.Request, state string) (StateData, bool)
+	WipeData(w http.ResponseWriter, r *http.Request, state string)
+}
+
+// RegisterStateStore sets the StateStore of the default client
 func RegisterStateStore(ss StateStore) {
-	defaultStateStore = ss
+	if ss != nil {
+		c := Default()
+		c.storeMu.Lock()
+		c.stateStore = ss
+		c.storeMu.Unlock()
+	}
 }
 
-var (
-	defaultStateStore StateStore = newStateStore()
-)
+// states returns the StateStore of the client
+func (c *Client) states() StateStore {
+	c.storeMu.RLock()
+	defer c.storeMu.RUnlock()
+	return c.stateStore
+}
 
 func newStateStore() StateStore {
 	return &stateStoreImpl{}
@@ -38,6 +80,38 @@ func (ssi *stateStoreImpl) Verify(r *http.Request, state string) bool {
 }
 func (ssi *stateStoreImpl) Wipe(w http.ResponseWriter, state string) {
 	StateUnset(w)
+	unsetCookie(w, cKeyStateData)
+}
+
+func (ssi *stateStoreImpl) SaveData(w http.ResponseWriter, r *http.Request, data StateData) error {
+	StateSet(w, data.State)
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	setCookie(w, cKeyStateData, base64.RawURLEncoding.EncodeToString(b))
+	return nil
+}
+
+func (ssi *stateStoreImpl) LoadData(r *http.Request, state string) (data StateData, ok bool) {
+	c, err := r.Cookie(cKeyStateData)
+	if err != nil {
+		return
+	}
+	b, err := base64.RawURLEncoding.DecodeString(c.Value)
+	if err != nil {
+		slog.Info("decode state data fail", "err", err)
+		return
+	}
+	if err = json.Unmarshal(b, &data); err != nil {
+		slog.Info("unmarshal state data fail", "err", err)
+		return
+	}
+	return data, len(state) > 0 && data.State == state && StateGet(r) == state
+}
+
+func (ssi *stateStoreImpl) WipeData(w http.ResponseWriter, r *http.Request, state string) {
+	ssi.Wipe(w, state)
 }
 
 func StateGet(r *http.Request) string {
@@ -50,20 +124,86 @@ func StateGet(r *http.Request) string {
 }
 
 func StateSet(w http.ResponseWriter, state string) {
+	setCookie(w, cKeyState, state)
+}
+
+func StateUnset(w http.ResponseWriter) {
+	unsetCookie(w, cKeyState)
+}
+
+func setCookie(w http.ResponseWriter, name, value string) {
 	http.SetCookie(w, &http.Cookie{
-		Name:     cKeyState,
-		Value:    state,
+		Name:     name,
+		Value:    value,
+		MaxAge:   int(StateTTL.Seconds()),
 		Path:     "/",
 		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
 	})
 }
 
-func StateUnset(w http.ResponseWriter) {
+func unsetCookie(w http.ResponseWriter, name string) {
 	http.SetCookie(w, &http.Cookie{
-		Name:     cKeyState,
+		Name:     name,
 		Value:    "",
 		MaxAge:   -1,
 		Path:     "/",
 		HttpOnly: true,
 	})
 }
+
+// stateStart generates a new state with a PKCE verifier (and a nonce in OIDC mode),
+// and saves them into the state store. The verifier and nonce are empty if the store can not keep them.
+func (c *Client) stateStart(w http.ResponseWriter, r *http.Request) (data StateData) {
+	data.State = randToken()
+	ss := c.states()
+	ds, ok := ss.(StateDataStore)
+	if !ok {
+		_ = ss.Save(w, data.State)
+		return
+	}
+	data.Verifier = oauth2.GenerateVerifier()
+	if c.oidc {
+		data.Nonce = randToken()
+	}
+	data.ReturnTo = c.safeReturnTo(r, r.FormValue(ParamReturnTo))
+	data.Expires = time.Now().Add(StateTTL).Unix()
+	if err := ds.SaveData(w, r, data); err != nil {
+		slog.Info("save state data fail", "err", err)
+		data.Verifier, data.Nonce, data.ReturnTo = "", "", ""
+		_ = ss.Save(w, data.State)
+	}
+	return
+}
+
+// stateVerify verifies the state of callback, and returns the data saved with it
+func (c *Client) stateVerify(r *http.Request, state string) (data StateData, ok bool) {
+	if len(state) == 0 {
+		return
+	}
+	ss := c.states()
+	if ds, is := ss.(StateDataStore); is {
+		// a missing or expired data is invalid, Verify would skip the checks of the expiry, PKCE and nonce
+		if data, ok = ds.LoadData(r, state); !ok || data.State != state || data.IsExpired() {
+			return StateData{}, false
+		}
+		return
+	}
+	return data, ss.Verify(r, state)
+}
+
+// stateWipe removes the state after used
+func (c *Client) stateWipe(w http.ResponseWriter, r *http.Request, state string) {
+	ss := c.states()
+	if ds, ok := ss.(StateDataStore); ok {
+		ds.WipeData(w, r, state)
+		return
+	}
+	ss.Wipe(w, state)
+}
+
+// StateDataFromContext returns the data saved with the state of the callback request
+func StateDataFromContext(ctx context.Context) (StateData, bool) {
+	sd, ok := ctx.Value(StateDataKey).(StateData)
+	return sd, ok
+}
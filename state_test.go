@@ -0,0 +1,172 @@
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+// requestWithCookies returns a callback request carrying the latest cookies set in recorders
+func requestWithCookies(recs ...*httptest.ResponseRecorder) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/auth/callback", nil)
+	cookies := map[string]*http.Cookie{}
+	for _, rec := range recs {
+		for _, c := range rec.Result().Cookies() {
+			cookies[c.Name] = c
+		}
+	}
+	for _, c := range cookies {
+		if c.MaxAge >= 0 {
+			r.AddCookie(c)
+		}
+	}
+	return r
+}
+
+func TestLoginStart_PKCE(t *testing.T) {
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/auth/login", nil)
+	location := LoginStart(rec, r)
+
+	u, err := url.Parse(location)
+	require.NoError(t, err)
+	q := u.Query()
+	state := q.Get("state")
+	assert.NotEmpty(t, state)
+	assert.Equal(t, "S256", q.Get("code_challenge_method"))
+
+	cr := requestWithCookies(rec)
+	sd, ok := Default().stateVerify(cr, state)
+	assert.True(t, ok)
+	require.NotEmpty(t, sd.Verifier)
+	assert.Equal(t, q.Get("code_challenge"), oauth2.S256ChallengeFromVerifier(sd.Verifier))
+
+	_, ok = Default().stateVerify(cr, "other")
+	assert.False(t, ok)
+}
+
+func TestStateStores_MultiTab(t *testing.T) {
+	cs, err := NewCookieStateStore("0123456789abcdef0123456789abcdef")
+	require.NoError(t, err)
+	_, err = NewCookieStateStore("short")
+	assert.Error(t, err)
+
+	stores := map[string]StateDataStore{
+		"cookie": cs,
+		"memory": NewMemoryStateStore(),
+	}
+	for name, ss := range stores {
+		t.Run(name, func(t *testing.T) {
+			exp := time.Now().Add(time.Minute).Unix()
+			rec1 := httptest.NewRecorder()
+			require.NoError(t, ss.SaveData(rec1, httptest.NewRequest(http.MethodGet, "/", nil),
+				StateData{State: "s1", ReturnTo: "/a", Expires: exp}))
+			rec2 := httptest.NewRecorder()
+			require.NoError(t, ss.SaveData(rec2, requestWithCookies(rec1),
+				StateData{State: "s2", ReturnTo: "/b", Expires: exp}))
+			rec3 := httptest.NewRecorder()
+			require.NoError(t, ss.SaveData(rec3, requestWithCookies(rec1, rec2),
+				StateData{State: "s3", Expires: time.Now().Add(-time.Second).Unix()}))
+
+			r := requestWithCookies(rec1, rec2, rec3)
+			sd, ok := ss.LoadData(r, "s1")
+			assert.True(t, ok)
+			assert.Equal(t, "/a", sd.ReturnTo)
+			sd, ok = ss.LoadData(r, "s2")
+			assert.True(t, ok)
+			assert.Equal(t, "/b", sd.ReturnTo)
+			_, ok = ss.LoadData(r, "s3")
+			assert.False(t, ok, "expired")
+
+			_, ok = ss.LoadData(httptest.NewRequest(http.MethodGet, "/", nil), "s1")
+			assert.False(t, ok, "other browser")
+
+			rec4 := httptest.NewRecorder()
+			ss.WipeData(rec4, r, "s1")
+			r = requestWithCookies(rec1, rec2, rec3, rec4)
+			_, ok = ss.LoadData(r, "s1")
+			assert.False(t, ok, "wiped")
+			_, ok = ss.LoadData(r, "s2")
+			assert.True(t, ok)
+		})
+	}
+}
+
+// lenientStateStore returns the saved data as is and verifies any state, like a store without the checks
+type lenientStateStore struct {
+	data StateData
+	ok   bool
+}
+
+func (s *lenientStateStore) Save(w http.ResponseWriter, state string) error { return nil }
+func (s *lenientStateStore) Verify(r *http.Request, state string) bool      { return true }
+func (s *lenientStateStore) Wipe(w http.ResponseWriter, state string)       {}
+func (s *lenientStateStore) SaveData(w http.ResponseWriter, r *http.Request, data StateData) error {
+	return nil
+}
+func (s *lenientStateStore) LoadData(r *http.Request, state string) (StateData, bool) {
+	return s.data, s.ok
+}
+func (s *lenientStateStore) WipeData(w http.ResponseWriter, r *http.Request, state string) {}
+
+func TestClient_StateVerify_Data(t *testing.T) {
+	exp := time.Now().Add(time.Minute).Unix()
+	tests := []struct {
+		name string
+		data StateData
+		ok   bool
+		want bool
+	}{
+		{"有效", StateData{State: "s1", Verifier: "v", Expires: exp}, true, true},
+		{"未找到", StateData{}, false, false},
+		{"已过期", StateData{State: "s1", Verifier: "v", Expires: time.Now().Add(-time.Second).Unix()}, true, false},
+		{"state 不匹配", StateData{State: "s2", Verifier: "v", Expires: exp}, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New(WithPrefix("https://sso.example.com"), WithClientID("id", "secret"),
+				WithStateStore(&lenientStateStore{data: tt.data, ok: tt.ok}))
+			sd, ok := c.stateVerify(httptest.NewRequest(http.MethodGet, "/auth/callback", nil), "s1")
+			assert.Equal(t, tt.want, ok)
+			if tt.want {
+				assert.Equal(t, "v", sd.Verifier)
+			} else {
+				assert.Empty(t, sd.Verifier)
+			}
+		})
+	}
+}
+
+func TestMemoryStateStore_Limits(t *testing.T) {
+	ss := NewMemoryStateStore()
+	rec := httptest.NewRecorder()
+	require.NoError(t, ss.SaveData(rec, nil, StateData{State: "b0"}))
+	r := requestWithCookies(rec)
+	for i := 1; i <= maxPendingStates; i++ {
+		require.NoError(t, ss.SaveData(httptest.NewRecorder(), r, StateData{State: "b" + strconv.Itoa(i)}))
+	}
+	_, ok := ss.LoadData(r, "b0")
+	assert.False(t, ok, "the oldest of the browser is dropped")
+	_, ok = ss.LoadData(r, "b1")
+	assert.True(t, ok)
+	assert.Len(t, ss.sids, 1)
+
+	for i := range maxMemoryStates {
+		require.NoError(t, ss.SaveData(httptest.NewRecorder(), nil, StateData{State: "o" + strconv.Itoa(i)}))
+	}
+	assert.Equal(t, maxMemoryStates, ss.ll.Len())
+	assert.Len(t, ss.items, maxMemoryStates)
+	_, ok = ss.LoadData(r, "b5")
+	assert.False(t, ok, "the oldest are dropped over the total limit")
+	assert.Len(t, ss.sids, maxMemoryStates)
+
+	ss.WipeData(nil, nil, "o0")
+	assert.Len(t, ss.sids, maxMemoryStates-1)
+}
@@ -0,0 +1,255 @@
+package client
+
+import (
+	"container/list"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	cKeyStates   = "staffio_states"
+	cKeyStateSID = "staffio_state_sid"
+
+	// maxPendingStates is the max number of pending states in a cookie, or of a browser in memory
+	maxPendingStates = 5
+	// maxMemoryStates is the max number of pending states in a MemoryStateStore
+	maxMemoryStates = 10000
+)
+
+// CookieStateStore keeps several pending states in an encrypted cookie, one per login tab
+type CookieStateStore struct {
+	aead cipher.AEAD
+}
+
+var (
+	_ StateStore     = (*CookieStateStore)(nil)
+	_ StateDataStore = (*CookieStateStore)(nil)
+)
+
+// NewCookieStateStore returns a CookieStateStore with the secret,
+// all instances of the app must share the same secret.
+func NewCookieStateStore(secret string) (*CookieStateStore, error) {
+	if len(secret) < 16 {
+		return nil, errors.New("the secret of state store is too short")
+	}
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &CookieStateStore{aead: aead}, nil
+}
+
+func (s *CookieStateStore) seal(list []StateData) (string, error) {
+	b, err := json.Marshal(list)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(s.aead.Seal(nonce, nonce, b, []byte(cKeyStates))), nil
+}
+
+func (s *CookieStateStore) open(r *http.Request) (list []StateData) {
+	if r == nil {
+		return
+	}
+	c, err := r.Cookie(cKeyStates)
+	if err != nil {
+		return
+	}
+	b, err := base64.RawURLEncoding.DecodeString(c.Value)
+	if err != nil || len(b) < s.aead.NonceSize() {
+		slog.Info("decode states fail", "err", err)
+		return
+	}
+	ns := s.aead.NonceSize()
+	b, err = s.aead.Open(nil, b[:ns], b[ns:], []byte(cKeyStates))
+	if err != nil {
+		slog.Info("open states fail", "err", err)
+		return
+	}
+	if err = json.Unmarshal(b, &list); err != nil {
+		slog.Info("unmarshal states fail", "err", err)
+		return nil
+	}
+	return slices.DeleteFunc(list, StateData.IsExpired)
+}
+
+func (s *CookieStateStore) write(w http.ResponseWriter, list []StateData) error {
+	if len(list) == 0 {
+		unsetCookie(w, cKeyStates)
+		return nil
+	}
+	value, err := s.seal(list)
+	if err != nil {
+		return err
+	}
+	setCookie(w, cKeyStates, value)
+	return nil
+}
+
+// SaveData appends the state to the pending ones, the oldest is dropped when full
+func (s *CookieStateStore) SaveData(w http.ResponseWriter, r *http.Request, data StateData) error {
+	list := append(s.open(r), data)
+	if n := len(list); n > maxPendingStates {
+		list = list[n-maxPendingStates:]
+	}
+	return s.write(w, list)
+}
+
+func (s *CookieStateStore) LoadData(r *http.Request, state string) (StateData, bool) {
+	for _, sd := range s.open(r) {
+		if len(state) > 0 && sd.State == state {
+			return sd, true
+		}
+	}
+	return StateData{}, false
+}
+
+func (s *CookieStateStore) WipeData(w http.ResponseWriter, r *http.Request, state string) {
+	list := slices.DeleteFunc(s.open(r), func(sd StateData) bool { return sd.State == state })
+	_ = s.write(w, list)
+}
+
+// Save keeps only one state, use SaveData instead
+func (s *CookieStateStore) Save(w http.ResponseWriter, state string) error {
+	return s.SaveData(w, nil, StateData{State: state, Expires: time.Now().Add(StateTTL).Unix()})
+}
+
+func (s *CookieStateStore) Verify(r *http.Request, state string) bool {
+	_, ok := s.LoadData(r, state)
+	return ok
+}
+
+// Wipe clears all pending states, use WipeData instead
+func (s *CookieStateStore) Wipe(w http.ResponseWriter, state string) {
+	unsetCookie(w, cKeyStates)
+}
+
+// MemoryStateStore keeps pending states in server memory, bound to the browser with a random cookie.
+// It works only with a single instance of the app. The oldest states are dropped over the limits,
+// maxPendingStates per browser and maxMemoryStates in total.
+type MemoryStateStore struct {
+	mu    sync.Mutex
+	ll    *list.List // of *memoryState, oldest first
+	items map[string]*list.Element
+	sids  map[string][]string // pending states of each browser, oldest first
+}
+
+type memoryState struct {
+	StateData
+	sid string
+}
+
+var (
+	_ StateStore     = (*MemoryStateStore)(nil)
+	_ StateDataStore = (*MemoryStateStore)(nil)
+)
+
+// NewMemoryStateStore returns a MemoryStateStore
+func NewMemoryStateStore() *MemoryStateStore {
+	return &MemoryStateStore{ll: list.New(), items: make(map[string]*list.Element), sids: make(map[string][]string)}
+}
+
+func stateSID(r *http.Request) string {
+	if r != nil {
+		if c, err := r.Cookie(cKeyStateSID); err == nil {
+			return c.Value
+		}
+	}
+	return ""
+}
+
+func (s *MemoryStateStore) SaveData(w http.ResponseWriter, r *http.Request, data StateData) error {
+	sid := stateSID(r)
+	if len(sid) == 0 {
+		sid = randToken()
+	}
+	setCookie(w, cKeyStateSID, sid)
+	if data.Expires == 0 {
+		data.Expires = time.Now().Add(StateTTL).Unix()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// states are saved with the same TTL mostly, so the expired ones are at the front
+	for e := s.ll.Front(); e != nil && e.Value.(*memoryState).IsExpired(); e = s.ll.Front() {
+		s.remove(e)
+	}
+	if e, ok := s.items[data.State]; ok {
+		s.remove(e)
+	}
+	for len(s.sids[sid]) >= maxPendingStates {
+		s.remove(s.items[s.sids[sid][0]])
+	}
+	for s.ll.Len() >= maxMemoryStates {
+		s.remove(s.ll.Front())
+	}
+	s.items[data.State] = s.ll.PushBack(&memoryState{StateData: data, sid: sid})
+	s.sids[sid] = append(s.sids[sid], data.State)
+	return nil
+}
+
+// remove drops the state of the element, s.mu must be held
+func (s *MemoryStateStore) remove(e *list.Element) {
+	ms := s.ll.Remove(e).(*memoryState)
+	delete(s.items, ms.State)
+	if rest := slices.DeleteFunc(s.sids[ms.sid], func(st string) bool { return st == ms.State }); len(rest) > 0 {
+		s.sids[ms.sid] = rest
+	} else {
+		delete(s.sids, ms.sid)
+	}
+}
+
+func (s *MemoryStateStore) LoadData(r *http.Request, state string) (StateData, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.items[state]
+	if !ok {
+		return StateData{}, false
+	}
+	ms := e.Value.(*memoryState)
+	if ms.IsExpired() || ms.sid != stateSID(r) {
+		return StateData{}, false
+	}
+	return ms.StateData, true
+}
+
+func (s *MemoryStateStore) WipeData(w http.ResponseWriter, r *http.Request, state string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.items[state]; ok {
+		s.remove(e)
+	}
+}
+
+// Save binds the state to a new browser session, use SaveData instead
+func (s *MemoryStateStore) Save(w http.ResponseWriter, state string) error {
+	return s.SaveData(w, nil, StateData{State: state})
+}
+
+func (s *MemoryStateStore) Verify(r *http.Request, state string) bool {
+	_, ok := s.LoadData(r, state)
+	return ok
+}
+
+func (s *MemoryStateStore) Wipe(w http.ResponseWriter, state string) {
+	s.WipeData(w, nil, state)
+}
@@ -0,0 +1,133 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	tracenoop "go.opentelemetry.io/otel/trace/noop"
+)
+
+const instrumentationName = "github.com/liut/staffio-client"
+
+// telemetry holds the tracer and the instruments, a no-op one is used by default
+type telemetry struct {
+	tracer trace.Tracer
+
+	attempts  metric.Int64Counter
+	successes metric.Int64Counter
+	failures  metric.Int64Counter
+	refreshes metric.Int64Counter
+	denials   metric.Int64Counter
+	exchange  metric.Float64Histogram
+}
+
+var noopTelemetry = newTelemetry(tracenoop.NewTracerProvider(), metricnoop.NewMeterProvider())
+
+// WithTelemetry enables OpenTelemetry traces and metrics of the auth flow and the calls to the provider,
+// nil means the global provider of otel.
+func WithTelemetry(tp trace.TracerProvider, mp metric.MeterProvider) Option {
+	return func(c *Client) {
+		if tp == nil {
+			tp = otel.GetTracerProvider()
+		}
+		if mp == nil {
+			mp = otel.GetMeterProvider()
+		}
+		c.telemetry = newTelemetry(tp, mp)
+	}
+}
+
+// WithTelemetryUID adds the UID of the user signed in to the staffio.Signin span,
+// which is personal data so it is off by default.
+func WithTelemetryUID() Option {
+	return func(c *Client) {
+		c.telemetryUID = true
+	}
+}
+
+func newTelemetry(tp trace.TracerProvider, mp metric.MeterProvider) *telemetry {
+	m := mp.Meter(instrumentationName)
+	t := &telemetry{tracer: tp.Tracer(instrumentationName)}
+	// errors of creating instruments are only about invalid names, which are constant here
+	t.attempts, _ = m.Int64Counter("staffio.login.attempts", metric.WithDescription("login callbacks received"))
+	t.successes, _ = m.Int64Counter("staffio.login.successes", metric.WithDescription("users signed in"))
+	t.failures, _ = m.Int64Counter("staffio.login.failures", metric.WithDescription("failed login callbacks, by reason"))
+	t.refreshes, _ = m.Int64Counter("staffio.token.refreshes", metric.WithDescription("tokens refreshed"))
+	t.denials, _ = m.Int64Counter("staffio.role.denials", metric.WithDescription("requests denied for missing role"))
+	t.exchange, _ = m.Float64Histogram("staffio.exchange.duration", metric.WithUnit("s"),
+		metric.WithDescription("latency of the code exchange"))
+	return t
+}
+
+func (c *Client) tel() *telemetry {
+	if c.telemetry != nil {
+		return c.telemetry
+	}
+	return noopTelemetry
+}
+
+func (t *telemetry) start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return t.tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+func (t *telemetry) exchanged(ctx context.Context, since time.Time, err error) {
+	t.exchange.Record(ctx, time.Since(since).Seconds(), metric.WithAttributes(attribute.Bool("error", err != nil)))
+}
+
+func (t *telemetry) denied(ctx context.Context, role string) {
+	t.denials.Add(ctx, 1, metric.WithAttributes(attribute.String("role", role)))
+}
+
+func spanEnd(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// tracingTransport traces calls to the provider as client spans, and propagates the trace context
+type tracingTransport struct {
+	base   http.RoundTripper
+	tracer trace.Tracer
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := t.tracer.Start(req.Context(), "HTTP "+req.Method, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("url.full", req.URL.Redacted()),
+			attribute.String("server.address", req.URL.Host),
+		))
+	req = req.Clone(ctx)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		spanEnd(span, err)
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= 500 {
+		span.SetStatus(codes.Error, resp.Status)
+	}
+	span.End()
+	return resp, nil
+}
+
+func (t *telemetry) wrap(hc *http.Client) *http.Client {
+	cp := *hc
+	base := hc.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	cp.Transport = &tracingTransport{base: base, tracer: t.tracer}
+	return &cp
+}
@@ -0,0 +1,121 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	auth "github.com/liut/simpauth"
+)
+
+func TestTelemetry_Callback(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"access_token": "at", "token_type": "Bearer", "expires_in": 3600})
+	})
+	mux.HandleFunc("/info/", func(w http.ResponseWriter, r *http.Request) {
+		assert.NotEmpty(t, r.Header.Get("Traceparent"), "trace context propagated")
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "at", "me": map[string]string{"uid": "alice"}, "group": []string{"staff"},
+		})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	old := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTextMapPropagator(old)
+
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	c := New(WithPrefix(srv.URL), WithClientID("cid", "secret"), WithTelemetry(tp, mp))
+
+	login := func(c *Client, roles ...string) int {
+		rec := httptest.NewRecorder()
+		authURL := c.LoginStart(rec, httptest.NewRequest(http.MethodGet, "/auth/login", nil))
+		u, _ := url.Parse(authURL)
+		r := requestWithCookies(rec)
+		r.URL.RawQuery = url.Values{"state": {u.Query().Get("state")}, "code": {"c1"}}.Encode()
+		rec = httptest.NewRecorder()
+		c.AuthCodeCallback(roles...).ServeHTTP(rec, r)
+		return rec.Code
+	}
+	assert.Equal(t, http.StatusFound, login(c, "staff"))
+	assert.Equal(t, http.StatusForbidden, login(c, "admin"))
+	failing := New(WithPrefix(srv.URL), WithClientID("cid", "secret"), WithTelemetry(tp, mp), WithTelemetryUID(),
+		WithAuthorizer(failSignin{NewAuth(WithCookie("_tel"))}))
+	assert.Equal(t, http.StatusInternalServerError, login(failing, "staff"))
+
+	var names []string
+	var uids []string
+	for _, s := range exporter.GetSpans() {
+		names = append(names, s.Name)
+		if s.Name == "staffio.Signin" {
+			var uid string
+			for _, kv := range s.Attributes {
+				if kv.Key == "uid" {
+					uid = kv.Value.AsString()
+				}
+			}
+			uids = append(uids, uid)
+		}
+	}
+	assert.Equal(t, []string{"", "alice"}, uids, "uid only with WithTelemetryUID")
+	for _, name := range []string{"staffio.LoginStart", "staffio.Callback", "staffio.VerifyState",
+		"staffio.Exchange", "staffio.RequestInfo", "staffio.CheckRole", "staffio.Signin", "HTTP POST", "HTTP GET"} {
+		assert.Contains(t, names, name)
+	}
+
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	sums := map[string]int64{}
+	var exchanges uint64
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			switch d := m.Data.(type) {
+			case metricdata.Sum[int64]:
+				for _, dp := range d.DataPoints {
+					sums[m.Name] += dp.Value
+					if v, ok := dp.Attributes.Value("reason"); ok {
+						assert.Contains(t, []string{"missing_role", "server_error"}, v.AsString())
+					}
+				}
+			case metricdata.Histogram[float64]:
+				for _, dp := range d.DataPoints {
+					exchanges += dp.Count
+				}
+			}
+		}
+	}
+	assert.EqualValues(t, 3, sums["staffio.login.attempts"])
+	assert.EqualValues(t, 1, sums["staffio.login.successes"], "not counted when Signin fails")
+	assert.EqualValues(t, 2, sums["staffio.login.failures"])
+	assert.EqualValues(t, 1, sums["staffio.role.denials"])
+	assert.EqualValues(t, 3, exchanges)
+}
+
+// failSignin is an Authorizer failing to sign in
+type failSignin struct {
+	auth.Authorizer
+}
+
+func (failSignin) Signin(user auth.Encoder, w http.ResponseWriter) error {
+	return errors.New("encode cookie fail")
+}
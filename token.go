@@ -3,8 +3,11 @@ package client
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net/http"
+	"slices"
 	"strings"
 	"time"
 
@@ -63,6 +66,127 @@ type InfoToken struct {
 	Me           *Staff     `json:"me,omitempty"`
 	Roles        auth.Names `json:"group,omitempty"`
 	Meta         Meta       `json:"meta,omitempty"`
+	Scope        string     `json:"scope,omitempty"`
+	// Extra holds the whitelisted extra fields of the token response, see WithTokenExtraKeys
+	Extra map[string]any `json:"extra,omitempty"`
+}
+
+// defaultTokenExtraKeys is the whitelist of the extra fields of the token response kept in InfoToken
+var defaultTokenExtraKeys = []string{"uid", "id_token", "sid"}
+
+// WithTokenExtraKeys adds keys to the whitelist of the extra fields of the token response kept
+// in InfoToken by the client, which are uid, id_token and sid by default. Other fields are dropped
+// since oauth2.Token exposes its extra fields by key only.
+func WithTokenExtraKeys(keys ...string) Option {
+	return func(c *Client) {
+		if c.tokenExtraKeys == nil {
+			c.tokenExtraKeys = slices.Clone(defaultTokenExtraKeys)
+		}
+		for _, k := range keys {
+			if len(k) > 0 && !slices.Contains(c.tokenExtraKeys, k) {
+				c.tokenExtraKeys = append(c.tokenExtraKeys, k)
+			}
+		}
+	}
+}
+
+// ErrTokenExpired is returned by InfoToken.Token when the access token is expired
+var ErrTokenExpired = errors.New("access token expired")
+
+// NewInfoToken converts a token response into an InfoToken, with the scope and the extra fields
+// of the default whitelist (uid, id_token and sid) only, the expiry is computed from expires_in
+// at receipt by oauth2. The client converts with its whitelist, see WithTokenExtraKeys.
+func NewInfoToken(tok *oauth2.Token) *InfoToken {
+	return newInfoToken(tok, defaultTokenExtraKeys)
+}
+
+// newInfoToken converts a token response with the whitelist of the client
+func (c *Client) newInfoToken(tok *oauth2.Token) *InfoToken {
+	if c.tokenExtraKeys == nil {
+		return NewInfoToken(tok)
+	}
+	return newInfoToken(tok, c.tokenExtraKeys)
+}
+
+func newInfoToken(tok *oauth2.Token, extraKeys []string) *InfoToken {
+	it := &InfoToken{
+		AccessToken:  tok.AccessToken,
+		TokenType:    tok.TokenType,
+		RefreshToken: tok.RefreshToken,
+		Expiry:       tok.Expiry,
+		ExpiresIn:    tok.ExpiresIn,
+	}
+	if it.ExpiresIn == 0 && !tok.Expiry.IsZero() {
+		it.ExpiresIn = int64(time.Until(tok.Expiry).Seconds())
+	}
+	if scope, ok := tok.Extra("scope").(string); ok {
+		it.Scope = scope
+	}
+	for _, k := range extraKeys {
+		if v := tok.Extra(k); v != nil {
+			if it.Extra == nil {
+				it.Extra = make(map[string]any)
+			}
+			it.Extra[k] = v
+		}
+	}
+	return it
+}
+
+// OAuth2Token converts the InfoToken back into an oauth2.Token, with the extra fields kept and the scope
+func (it *InfoToken) OAuth2Token() *oauth2.Token {
+	tok := &oauth2.Token{
+		AccessToken:  it.AccessToken,
+		TokenType:    it.TokenType,
+		RefreshToken: it.RefreshToken,
+		Expiry:       it.Expiry,
+		ExpiresIn:    it.ExpiresIn,
+	}
+	if len(it.Extra) == 0 && len(it.Scope) == 0 {
+		return tok
+	}
+	extra := make(map[string]any, len(it.Extra)+1)
+	for k, v := range it.Extra {
+		extra[k] = v
+	}
+	if len(it.Scope) > 0 {
+		extra["scope"] = it.Scope
+	}
+	return tok.WithExtra(extra)
+}
+
+// Token returns the access token, it makes InfoToken an oauth2.TokenSource without refreshing,
+// see InfoTokenSource for that.
+func (it *InfoToken) Token() (*oauth2.Token, error) {
+	tok := it.OAuth2Token()
+	if !tok.Valid() {
+		return nil, ErrTokenExpired
+	}
+	return tok, nil
+}
+
+// UnmarshalJSON decodes the InfoToken, the group is a list or a RoleMe,
+// and computes Expiry from expires_in at receipt if absent
+func (it *InfoToken) UnmarshalJSON(b []byte) error {
+	type plain InfoToken
+	aux := struct {
+		*plain
+		Group any `json:"group,omitempty"`
+	}{plain: (*plain)(it)}
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+	if aux.Group != nil {
+		roles, err := rolesFromAny(aux.Group)
+		if err != nil {
+			return fmt.Errorf("group: %w", err)
+		}
+		it.Roles = roles
+	}
+	if it.Expiry.IsZero() && it.ExpiresIn > 0 {
+		it.Expiry = time.Now().Add(time.Duration(it.ExpiresIn) * time.Second)
+	}
+	return nil
 }
 
 // GetUser 从 InfoToken 中提取用户信息。
@@ -77,7 +201,7 @@ func (it *InfoToken) GetUser() (*O2User, bool) {
 	if it.Me != nil {
 		*user = it.Me.ToO2User()
 	} else if it.User != nil {
-		user = it.User
+		*user = *it.User
 		if len(user.OID) == 0 && len(it.User.Sub) > 0 {
 			user.OID = it.User.Sub
 		}
@@ -88,15 +212,89 @@ func (it *InfoToken) GetUser() (*O2User, bool) {
 	return user, true
 }
 
+// uid returns the UID of Me or User, empty if none
+func (it *InfoToken) uid() string {
+	if it.Me != nil {
+		return it.Me.UID
+	}
+	if it.User != nil {
+		return it.User.UID
+	}
+	return ""
+}
+
+// HasRole checks the role is granted, exactly
 func (it *InfoToken) HasRole(slug string) bool {
-	return it.Roles.Has(slug)
+	return it.RoleSet().Has(slug)
+}
+
+// HasScope checks the scope granted, which is space-delimited
+func (it *InfoToken) HasScope(scope string) bool {
+	return it.Scopes().Has(scope)
+}
+
+// Scopes returns the scopes granted
+func (it *InfoToken) Scopes() Scopes {
+	return ParseScopes(it.Scope)
+}
+
+// UID returns the uid of the user, or the uid of the token response
+func (it *InfoToken) UID() string {
+	if uid := it.uid(); len(uid) > 0 {
+		return uid
+	}
+	uid, _ := it.Extra["uid"].(string)
+	return uid
+}
+
+// IDToken returns the raw id_token of the token response, empty if none
+func (it *InfoToken) IDToken() string {
+	s, _ := it.Extra["id_token"].(string)
+	return s
 }
 
-// GetExpiry ...
+// GetExpiry returns the expiry, which is computed once at receipt
 func (tok *InfoToken) GetExpiry() time.Time {
+	if !tok.Expiry.IsZero() {
+		return tok.Expiry
+	}
 	return time.Now().Add(time.Duration(tok.ExpiresIn) * time.Second)
 }
 
+// Scopes is a set of OAuth2 scopes
+type Scopes []string
+
+// ParseScopes parses space-delimited scopes, duplicates are removed
+func ParseScopes(s string) Scopes {
+	var ss Scopes
+	for _, scope := range strings.Fields(s) {
+		if !ss.Has(scope) {
+			ss = append(ss, scope)
+		}
+	}
+	return ss
+}
+
+// Has checks the scope is in the set
+func (ss Scopes) Has(scope string) bool {
+	return slices.Contains(ss, scope)
+}
+
+// HasAll checks all of the scopes are in the set
+func (ss Scopes) HasAll(scopes ...string) bool {
+	for _, scope := range scopes {
+		if !ss.Has(scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the space-delimited scopes
+func (ss Scopes) String() string {
+	return strings.Join(ss, " ")
+}
+
 type InfoError struct {
 	ErrCode    string `json:"error,omitempty"`
 	ErrMessage string `json:"error_description,omitempty"`
@@ -104,7 +302,7 @@ type InfoError struct {
 
 func (e InfoError) GetError() error {
 	if len(e.ErrCode) > 0 {
-		return fmt.Errorf("%s: %s", e.ErrCode, e.ErrMessage)
+		return &OAuthError{Code: e.ErrCode, Description: e.ErrMessage, Status: http.StatusUnauthorized}
 	}
 	return nil
 }
@@ -112,36 +310,54 @@ func (e InfoError) GetError() error {
 // RequestInfo calls the info API with the given token and unmarshals the response into obj.
 // The optional parts are joined with "|" and appended to the info URI.
 func RequestInfo(ctx context.Context, tok *oauth2.Token, obj any, parts ...string) error {
-	uri := infoURI
+	return Default().RequestInfo(ctx, tok, obj, parts...)
+}
+
+// RequestInfo calls the info API with the given token and unmarshals the response into obj.
+// The optional parts are joined with "|" and appended to the info URI.
+func (c *Client) RequestInfo(ctx context.Context, tok *oauth2.Token, obj any, parts ...string) error {
+	uri := c.infoURI
 	if len(parts) > 0 {
-		uri = infoURI + "|" + strings.Join(parts, "|")
+		uri = c.infoURI + "|" + strings.Join(parts, "|")
 	}
-	return RequestWith(ctx, uri, tok, obj)
+	return c.RequestWith(ctx, uri, tok, obj)
 }
 
 // RequestWith performs an HTTP GET request to the specified URI with the OAuth2 token
 // and unmarshals the JSON response into obj.
 func RequestWith(ctx context.Context, uri string, tok *oauth2.Token, obj any) error {
-	ctxEx := context.WithValue(ctx, oauth2.HTTPClient, httpClient)
-	client := confSgt().Client(ctxEx, tok)
-	resp, err := client.Get(uri)
-	if err != nil {
-		slog.Info("get resp fail", "err", err, "uri", "uri")
-		return err
-	}
-	defer resp.Body.Close()
-	err = json.NewDecoder(resp.Body).Decode(obj)
-	if err != nil {
-		slog.Info("unmarshal fail", "err", err, "sc", resp.StatusCode, "uri", uri)
-		return err
-	}
-	return nil
+	return Default().RequestWith(ctx, uri, tok, obj)
+}
+
+// RequestWith performs an HTTP GET request to the specified URI with the OAuth2 token
+// and unmarshals the JSON response into obj.
+func (c *Client) RequestWith(ctx context.Context, uri string, tok *oauth2.Token, obj any) error {
+	return c.Do(ctx, http.MethodGet, uri, tok, nil, obj)
 }
 
 // RequestInfoToken requests an InfoToken using the given token and optionally filters by roles.
 func RequestInfoToken(ctx context.Context, tok *oauth2.Token, roles ...string) (*InfoToken, error) {
+	return Default().RequestInfoToken(ctx, tok, roles...)
+}
+
+// RequestInfoToken requests an InfoToken using the given token and optionally filters by roles,
+// the result is cached if an InfoCache is set.
+func (c *Client) RequestInfoToken(ctx context.Context, tok *oauth2.Token, roles ...string) (*InfoToken, error) {
+	if c.infoCache != nil && tok != nil && len(tok.AccessToken) > 0 {
+		return c.cachedInfoToken(ctx, tok, roles)
+	}
+	return c.requestInfoToken(ctx, tok, roles...)
+}
+
+// requestInfoToken requests the provider, and adds the roles implied by the RoleHierarchy
+func (c *Client) requestInfoToken(ctx context.Context, tok *oauth2.Token, roles ...string) (*InfoToken, error) {
+	if c.oidc {
+		it, err := c.requestUserInfo(ctx, tok)
+		c.expandRoles(it)
+		return it, err
+	}
 	it := new(InfoToken)
-	err := RequestInfo(ctx, tok, it, roles...)
+	err := c.RequestInfo(ctx, tok, it, roles...)
 	if err != nil {
 		return nil, err
 	}
@@ -153,5 +369,14 @@ func RequestInfoToken(ctx context.Context, tok *oauth2.Token, roles ...string) (
 		slog.Debug("infoToken", "user", it.User)
 	}
 	it.Expiry = it.GetExpiry()
+	for k, v := range c.newInfoToken(tok).Extra {
+		if it.Extra == nil {
+			it.Extra = make(map[string]any)
+		}
+		if _, ok := it.Extra[k]; !ok {
+			it.Extra[k] = v
+		}
+	}
+	c.expandRoles(it)
 	return it, nil
 }
@@ -1,10 +1,13 @@
 package client
 
 import (
+	"encoding/json"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
 )
 
 func TestInfoError_GetError(t *testing.T) {
@@ -70,3 +73,52 @@ func TestInfoToken_GetExpiry(t *testing.T) {
 
 	assert.True(t, expiry.After(expected), "Expiry should be in the future")
 }
+
+func TestInfoToken_OAuth2Token(t *testing.T) {
+	expiry := time.Now().Add(time.Hour).Round(time.Second)
+	tok := (&oauth2.Token{AccessToken: "at", TokenType: "Bearer", RefreshToken: "rt", Expiry: expiry}).
+		WithExtra(map[string]any{"uid": "alice", "id_token": "a.b.c", "scope": "openid staff openid", "tenant": "t1"})
+
+	it := NewInfoToken(tok)
+	assert.Equal(t, "alice", it.UID())
+	assert.Equal(t, "a.b.c", it.IDToken())
+	assert.Equal(t, Scopes{"openid", "staff"}, it.Scopes())
+	assert.True(t, it.Scopes().HasAll("staff", "openid"))
+	assert.False(t, it.HasScope("admin"))
+	assert.Equal(t, expiry, it.GetExpiry())
+
+	back := it.OAuth2Token()
+	assert.Equal(t, tok.AccessToken, back.AccessToken)
+	assert.Equal(t, tok.RefreshToken, back.RefreshToken)
+	assert.Equal(t, expiry, back.Expiry)
+	for _, k := range []string{"uid", "id_token", "scope"} {
+		assert.Equal(t, tok.Extra(k), back.Extra(k), k)
+	}
+	assert.Nil(t, back.Extra("tenant"), "not in the whitelist")
+
+	c := New(WithTokenExtraKeys("tenant"))
+	assert.Equal(t, "t1", c.newInfoToken(tok).Extra["tenant"])
+	assert.Equal(t, "alice", c.newInfoToken(tok).UID())
+	assert.Nil(t, New().newInfoToken(tok).Extra["tenant"], "per client")
+
+	var ts oauth2.TokenSource = it
+	got, err := ts.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "at", got.AccessToken)
+	it.Expiry = time.Now().Add(-time.Minute)
+	_, err = ts.Token()
+	assert.ErrorIs(t, err, ErrTokenExpired)
+}
+
+func TestInfoToken_UnmarshalJSON(t *testing.T) {
+	var it InfoToken
+	require.NoError(t, json.Unmarshal([]byte(`{"access_token":"at","expires_in":60,"error":"x"}`), &it))
+	assert.Equal(t, "x", it.ErrCode)
+	expiry := it.Expiry
+	assert.WithinDuration(t, time.Now().Add(time.Minute), expiry, time.Second)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, expiry, it.GetExpiry(), "computed once")
+
+	require.NoError(t, json.Unmarshal([]byte(`{"access_token":"at","expires_in":60,"expiry":"2030-01-01T00:00:00Z"}`), &it))
+	assert.Equal(t, 2030, it.Expiry.Year())
+}
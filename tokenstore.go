@@ -0,0 +1,232 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/oauth2"
+)
+
+// ErrTokenNotFound is returned by a TokenStore when the key is not found
+var ErrTokenNotFound = errors.New("token not found in store")
+
+// TokenStore keeps oauth2 tokens of signed in users, keyed by UID or session ID
+type TokenStore interface {
+	Get(ctx context.Context, key string) (*oauth2.Token, error)
+	Put(ctx context.Context, key string, tok *oauth2.Token) error
+	Delete(ctx context.Context, key string) error
+}
+
+// WithTokenStore sets the TokenStore, which is populated by the code callback
+func WithTokenStore(ts TokenStore) Option {
+	return func(c *Client) {
+		c.tokenStore = ts
+	}
+}
+
+// RegisterTokenStore sets the TokenStore of the default client
+func RegisterTokenStore(ts TokenStore) {
+	c := Default()
+	c.storeMu.Lock()
+	c.tokenStore = ts
+	c.storeMu.Unlock()
+}
+
+// TokenStore returns the TokenStore of the client, nil if not set
+func (c *Client) TokenStore() TokenStore {
+	c.storeMu.RLock()
+	defer c.storeMu.RUnlock()
+	return c.tokenStore
+}
+
+// TokenSourceForUser returns a TokenSource of the user in the default client
+func TokenSourceForUser(ctx context.Context, uid string) (oauth2.TokenSource, error) {
+	return Default().TokenSourceForUser(ctx, uid)
+}
+
+// TokenSourceForUser returns a TokenSource with the stored token of the user,
+// which refreshes the token when expired and persists rotated tokens back to the store.
+func (c *Client) TokenSourceForUser(ctx context.Context, uid string) (oauth2.TokenSource, error) {
+	ts := c.TokenStore()
+	if ts == nil {
+		return nil, ErrTokenNotFound
+	}
+	tok, err := ts.Get(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	return c.storedTokenSource(ctx, ts, uid, tok), nil
+}
+
+func (c *Client) storedTokenSource(ctx context.Context, ts TokenStore, key string, tok *oauth2.Token) *storedTokenSource {
+	ctxEx := context.WithValue(ctx, oauth2.HTTPClient, c.httpClient)
+	return &storedTokenSource{
+		ctx:   ctx,
+		src:   c.conf.TokenSource(ctxEx, tok),
+		store: ts,
+		key:   key,
+		c:     c,
+		last:  tok.AccessToken,
+	}
+}
+
+// storedTokenSource writes the token back to the store when it changed
+type storedTokenSource struct {
+	ctx   context.Context
+	src   oauth2.TokenSource
+	store TokenStore
+	key   string
+	c     *Client
+
+	mu   sync.Mutex
+	last string
+}
+
+func (s *storedTokenSource) Token() (*oauth2.Token, error) {
+	tok, err := s.src.Token()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if tok.AccessToken != s.last {
+		s.c.tel().refreshes.Add(s.ctx, 1)
+		s.c.audit(s.ctx, nil, AuditEvent{Type: AuditTokenRefreshed, UID: s.key})
+		if err = s.store.Put(s.ctx, s.key, tok); err != nil {
+			slog.Info("persist refreshed token fail", "key", s.key, "err", err)
+		}
+		s.last = tok.AccessToken
+	}
+	return tok, nil
+}
+
+// MemoryTokenStore is an in-memory TokenStore
+type MemoryTokenStore struct {
+	mu     sync.RWMutex
+	tokens map[string]*oauth2.Token
+}
+
+var _ TokenStore = (*MemoryTokenStore)(nil)
+
+// NewMemoryTokenStore returns an in-memory TokenStore
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{tokens: make(map[string]*oauth2.Token)}
+}
+
+func (s *MemoryTokenStore) Get(ctx context.Context, key string) (*oauth2.Token, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if tok, ok := s.tokens[key]; ok {
+		return tok, nil
+	}
+	return nil, ErrTokenNotFound
+}
+
+func (s *MemoryTokenStore) Put(ctx context.Context, key string, tok *oauth2.Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[key] = tok
+	return nil
+}
+
+func (s *MemoryTokenStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tokens, key)
+	return nil
+}
+
+// FileTokenStore is a TokenStore that keeps tokens in a JSON file,
+// it is suitable for a single process with a small number of users.
+type FileTokenStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+var _ TokenStore = (*FileTokenStore)(nil)
+
+// NewFileTokenStore returns a TokenStore with the file path
+func NewFileTokenStore(path string) *FileTokenStore {
+	return &FileTokenStore{path: path}
+}
+
+func (s *FileTokenStore) load() (map[string]*oauth2.Token, error) {
+	tokens := make(map[string]*oauth2.Token)
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return tokens, nil
+		}
+		return nil, err
+	}
+	if len(b) > 0 {
+		if err = json.Unmarshal(b, &tokens); err != nil {
+			return nil, err
+		}
+	}
+	return tokens, nil
+}
+
+func (s *FileTokenStore) save(tokens map[string]*oauth2.Token) error {
+	b, err := json.Marshal(tokens)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(b); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err = f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), s.path)
+}
+
+func (s *FileTokenStore) Get(ctx context.Context, key string) (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tokens, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	if tok, ok := tokens[key]; ok {
+		return tok, nil
+	}
+	return nil, ErrTokenNotFound
+}
+
+func (s *FileTokenStore) Put(ctx context.Context, key string, tok *oauth2.Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tokens, err := s.load()
+	if err != nil {
+		return err
+	}
+	tokens[key] = tok
+	return s.save(tokens)
+}
+
+func (s *FileTokenStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tokens, err := s.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := tokens[key]; !ok {
+		return nil
+	}
+	delete(tokens, key)
+	return s.save(tokens)
+}
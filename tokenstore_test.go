@@ -0,0 +1,73 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestTokenStores(t *testing.T) {
+	stores := map[string]TokenStore{
+		"memory": NewMemoryTokenStore(),
+		"file":   NewFileTokenStore(filepath.Join(t.TempDir(), "tokens.json")),
+	}
+	ctx := context.Background()
+	for name, ts := range stores {
+		t.Run(name, func(t *testing.T) {
+			_, err := ts.Get(ctx, "alice")
+			assert.ErrorIs(t, err, ErrTokenNotFound)
+
+			require.NoError(t, ts.Put(ctx, "alice", &oauth2.Token{AccessToken: "a1", RefreshToken: "r1"}))
+			tok, err := ts.Get(ctx, "alice")
+			require.NoError(t, err)
+			assert.Equal(t, "a1", tok.AccessToken)
+			assert.Equal(t, "r1", tok.RefreshToken)
+
+			require.NoError(t, ts.Delete(ctx, "alice"))
+			_, err = ts.Get(ctx, "alice")
+			assert.ErrorIs(t, err, ErrTokenNotFound)
+		})
+	}
+}
+
+func TestClient_TokenSourceForUser(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		assert.Equal(t, "refresh_token", r.PostForm.Get("grant_type"))
+		assert.Equal(t, "r1", r.PostForm.Get("refresh_token"))
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "a2", "token_type": "Bearer", "refresh_token": "r2", "expires_in": 3600,
+		})
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	store := NewMemoryTokenStore()
+	c := New(WithPrefix(srv.URL), WithClientID("cid", "secret"), WithTokenStore(store))
+
+	_, err := c.TokenSourceForUser(ctx, "alice")
+	assert.ErrorIs(t, err, ErrTokenNotFound)
+
+	expired := &oauth2.Token{AccessToken: "a1", RefreshToken: "r1", Expiry: time.Now().Add(-time.Minute)}
+	require.NoError(t, store.Put(ctx, "alice", expired))
+
+	ts, err := c.TokenSourceForUser(ctx, "alice")
+	require.NoError(t, err)
+	tok, err := ts.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "a2", tok.AccessToken)
+
+	stored, err := store.Get(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, "a2", stored.AccessToken)
+	assert.Equal(t, "r2", stored.RefreshToken)
+}
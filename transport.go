@@ -0,0 +1,138 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+const defaultTimeout = 9 * time.Second
+
+// TLSOptions configures TLS of the http client to the provider
+type TLSOptions struct {
+	// CAFile is a PEM bundle of trusted CAs, appended to the system pool
+	CAFile string
+	// CertFile and KeyFile are the client certificate for mTLS
+	CertFile string
+	KeyFile  string
+	// MinVersion is the minimum TLS version, default is TLS 1.2
+	MinVersion uint16
+	// Insecure skips verification of the server certificate, for local development only
+	Insecure bool
+}
+
+// ParseTLSVersion parses a version like "1.2" or "1.3"
+func ParseTLSVersion(s string) (uint16, error) {
+	switch s {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unsupported tls version %q", s)
+}
+
+// Config builds a tls.Config
+func (o TLSOptions) Config() (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if o.MinVersion > 0 {
+		cfg.MinVersion = o.MinVersion
+	}
+	if len(o.CAFile) > 0 {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", o.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if len(o.CertFile) > 0 || len(o.KeyFile) > 0 {
+		if len(o.CertFile) == 0 || len(o.KeyFile) == 0 {
+			return nil, errors.New("both cert and key files are required for client certificate")
+		}
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if o.Insecure {
+		slog.Warn("TLS verification of the provider is disabled, DO NOT use it in production")
+		cfg.InsecureSkipVerify = true
+	}
+	return cfg, nil
+}
+
+// NewHTTPClient returns an http.Client with TLSOptions
+func NewHTTPClient(o TLSOptions) (*http.Client, error) {
+	cfg, err := o.Config()
+	if err != nil {
+		return nil, err
+	}
+	return newHTTPClient(cfg), nil
+}
+
+func newHTTPClient(cfg *tls.Config) *http.Client {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg != nil {
+		tr.TLSClientConfig = cfg
+	}
+	return &http.Client{Timeout: defaultTimeout, Transport: tr}
+}
+
+// WithTLSConfig sets the tls.Config of the http client to the provider
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(c *Client) {
+		if cfg != nil {
+			if cfg.InsecureSkipVerify {
+				slog.Warn("TLS verification of the provider is disabled, DO NOT use it in production")
+			}
+			c.httpClient = newHTTPClient(cfg)
+		}
+	}
+}
+
+// SetHTTPClient replaces the http client of the default client, ex: an instrumented one
+func SetHTTPClient(hc *http.Client) {
+	Default().SetHTTPClient(hc)
+}
+
+// SetHTTPClient replaces the http client to the provider, wrapped with the retry and tracing
+// transports of WithRetry and WithTelemetry like the one of WithHTTPClient. Call it at setup,
+// the service token sources created before keep the previous client.
+func (c *Client) SetHTTPClient(hc *http.Client) {
+	if hc == nil {
+		return
+	}
+	c.httpClient = c.wrapHTTPClient(hc)
+	if c.keys != nil {
+		c.keys.hc = c.httpClient
+	}
+}
+
+// wrapHTTPClient wraps the http client with the retry transport, then the tracing one, if configured
+func (c *Client) wrapHTTPClient(hc *http.Client) *http.Client {
+	if c.retry != nil {
+		hc = c.retry.wrap(hc)
+	}
+	if c.telemetry != nil {
+		hc = c.telemetry.wrap(hc)
+	}
+	return hc
+}
+
+// HTTPClient returns the http client to the provider
+func (c *Client) HTTPClient() *http.Client {
+	return c.httpClient
+}
@@ -0,0 +1,70 @@
+package client
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewHTTPClient_TLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	block := &pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(block), 0600))
+
+	tests := []struct {
+		name    string
+		opts    TLSOptions
+		success bool
+	}{
+		{"默认校验证书", TLSOptions{}, false},
+		{"自定义CA", TLSOptions{CAFile: caFile}, true},
+		{"跳过校验", TLSOptions{Insecure: true}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hc, err := NewHTTPClient(tt.opts)
+			require.NoError(t, err)
+			resp, err := hc.Get(srv.URL)
+			if tt.success {
+				require.NoError(t, err)
+				resp.Body.Close()
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+
+	_, err := NewHTTPClient(TLSOptions{CAFile: filepath.Join(t.TempDir(), "none.pem")})
+	assert.Error(t, err)
+	_, err = NewHTTPClient(TLSOptions{CertFile: caFile})
+	assert.Error(t, err)
+}
+
+func TestParseTLSVersion(t *testing.T) {
+	v, err := ParseTLSVersion("1.3")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0x0304, v)
+	_, err = ParseTLSVersion("1.0")
+	assert.Error(t, err)
+}
+
+func TestClient_SetHTTPClient(t *testing.T) {
+	c := New(WithRetry(RetryPolicy{MaxRetries: 1}), WithTelemetry(nil, nil))
+	hc := &http.Client{Transport: http.DefaultTransport}
+	c.SetHTTPClient(hc)
+
+	tt, ok := c.HTTPClient().Transport.(*tracingTransport)
+	require.True(t, ok, "tracing kept")
+	_, ok = tt.base.(*RetryTransport)
+	assert.True(t, ok, "retry kept")
+	assert.Same(t, http.DefaultTransport, hc.Transport, "the given client is not modified")
+}